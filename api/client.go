@@ -0,0 +1,366 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	defaultChunkSize  = 4 * 1024 * 1024
+)
+
+type (
+	// Client to communicate with flow.ci server
+	Client interface {
+		// Connect register agent to server and get settings back
+		Connect(init *domain.AgentInit) (*domain.Settings, error)
+
+		// ReportProfile send agent resource profile to server
+		ReportProfile(profile *domain.Resource) error
+
+		// UploadLog upload cmd log file to server with gzip, the large file is uploaded in chunks and resumed
+		// from the bytes received by server
+		UploadLog(filePath string) error
+
+		// GetPluginChecksum get sha256 checksum of plugin files for the version
+		GetPluginChecksum(name, version string) (string, error)
+	}
+
+	// Options for server client, default value will be applied if not set
+	Options struct {
+		Server     string
+		Token      string
+		Proxy      string
+		Timeout    time.Duration
+		MaxRetries int
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+		ChunkSize  int64 // in bytes, log file larger than it is uploaded in chunks
+	}
+
+	// body creates request body for each attempt, since reader cannot be reused
+	bodyFunc func() (body io.Reader, contentType string, err error)
+
+	client struct {
+		server  string
+		token   string
+		options Options
+		http    *http.Client
+	}
+)
+
+// NewClient create server client shared by the agent
+func NewClient(options Options) Client {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = defaultMinBackoff
+	}
+
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
+
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = defaultChunkSize
+	}
+
+	transport := &http.Transport{
+		Proxy: proxyFunc(options.Proxy),
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &client{
+		server:  strings.TrimRight(options.Server, "/"),
+		token:   options.Token,
+		options: options,
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+		},
+	}
+}
+
+// DefaultOptions options with default retry settings
+func DefaultOptions(server, token string) Options {
+	return Options{
+		Server:     server,
+		Token:      token,
+		MaxRetries: defaultMaxRetries,
+	}
+}
+
+func (c *client) Connect(init *domain.AgentInit) (*domain.Settings, error) {
+	var message domain.SettingsResponse
+	err := c.send("POST", "/agents/connect", jsonBody(init), &message)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.Data == nil {
+		return nil, &ResponseError{Code: message.Code, Message: "settings is missing"}
+	}
+
+	return message.Data, nil
+}
+
+func (c *client) ReportProfile(profile *domain.Resource) error {
+	return c.send("POST", "/agents/resource", jsonBody(profile), nil)
+}
+
+func (c *client) UploadLog(filePath string) error {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return &ResponseError{Code: -1, Message: err.Error()}
+	}
+
+	if stat.Size() > c.options.ChunkSize {
+		return c.uploadLogInChunks(filePath, stat.Size())
+	}
+
+	return c.send("POST", "/agents/logs/upload", gzipFileBody(filePath), nil)
+}
+
+func (c *client) GetPluginChecksum(name, version string) (string, error) {
+	path := fmt.Sprintf("/agents/plugins/%s/checksum?version=%s", url.PathEscape(name), url.QueryEscape(version))
+
+	var message domain.PluginChecksumResponse
+	err := c.send("GET", path, emptyBody, &message)
+	if err != nil {
+		return util.EmptyStr, err
+	}
+
+	return message.Data, nil
+}
+
+//====================================================================
+//	private
+//====================================================================
+
+// send request with retry, the out should be pointer of struct which embed domain.Response
+func (c *client) send(method, path string, body bodyFunc, out interface{}) (err error) {
+	for attempt := 0; attempt <= c.options.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := util.Backoff(attempt-1, c.options.MinBackoff, c.options.MaxBackoff)
+			util.LogDebug("Retry %s %s in %s: %v", method, path, wait, err)
+			time.Sleep(wait)
+		}
+
+		err = c.sendOnce(method, path, body, out)
+		if err == nil || IsResponseError(err) {
+			return
+		}
+	}
+
+	return
+}
+
+func (c *client) sendOnce(method, path string, body bodyFunc, out interface{}) error {
+	reader, contentType, err := body()
+	if err != nil {
+		return &ResponseError{Code: -1, Message: err.Error()}
+	}
+
+	request, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return &ResponseError{Code: -1, Message: err.Error()}
+	}
+
+	if !util.IsEmptyString(contentType) {
+		request.Header.Set(util.HttpHeaderContentType, contentType)
+	}
+
+	request.Header.Set(util.HttpHeaderAgentToken, c.token)
+
+	resp, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// server side error or throttled, should be retried
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%s: http status %d", ErrorServerUnavailable.Error(), resp.StatusCode)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &ResponseError{Code: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+
+	var message domain.Response
+	if err = json.Unmarshal(raw, &message); err != nil {
+		return &ResponseError{Code: resp.StatusCode, Message: err.Error()}
+	}
+
+	if !message.IsOk() {
+		return &ResponseError{Code: message.Code, Message: message.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(raw, out); err != nil {
+		return &ResponseError{Code: resp.StatusCode, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// each chunk is gzip compressed and appended on server, which is a valid gzip file of multiple members
+func (c *client) uploadLogInChunks(filePath string, size int64) error {
+	path := "/agents/logs/upload/" + url.PathEscape(filepath.Base(filePath))
+
+	var message domain.LogUploadResponse
+	if err := c.send("GET", path, emptyBody, &message); err != nil {
+		return err
+	}
+
+	for offset := message.Data; offset < size; offset += c.options.ChunkSize {
+		length := c.options.ChunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		query := fmt.Sprintf("?offset=%d&length=%d&total=%d", offset, length, size)
+		if err := c.send("PUT", path+query, gzipChunkBody(filePath, offset, length), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func emptyBody() (io.Reader, string, error) {
+	return nil, util.EmptyStr, nil
+}
+
+func jsonBody(v interface{}) bodyFunc {
+	return func() (io.Reader, string, error) {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(raw), util.HttpMimeJson, nil
+	}
+}
+
+// multipart body of gzip compressed file, which is written to pipe in background
+func gzipFileBody(filePath string) bodyFunc {
+	return func() (io.Reader, string, error) {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		reader, writer := io.Pipe()
+		form := multipart.NewWriter(writer)
+
+		go func() {
+			defer file.Close()
+
+			err := writeGzipPart(form, file, filepath.Base(filePath)+".gz")
+			if err == nil {
+				err = form.Close()
+			}
+
+			_ = writer.CloseWithError(err)
+		}()
+
+		return reader, form.FormDataContentType(), nil
+	}
+}
+
+func writeGzipPart(form *multipart.Writer, file io.Reader, fileName string) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName))
+	header.Set(util.HttpHeaderContentType, util.HttpMimeGzip)
+
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(part)
+	if _, err = io.Copy(writer, file); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// gzip compressed bytes of file section, the chunk is loaded in memory to be reused on retry
+func gzipChunkBody(filePath string, offset, length int64) bodyFunc {
+	return func() (io.Reader, string, error) {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+
+		if _, err = io.Copy(writer, io.NewSectionReader(file, offset, length)); err != nil {
+			return nil, "", err
+		}
+
+		if err = writer.Close(); err != nil {
+			return nil, "", err
+		}
+
+		return buffer, util.HttpMimeGzip, nil
+	}
+}
+
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	if util.IsEmptyString(proxy) {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		util.LogWarn("Invalid proxy '%s', use proxy from environment", proxy)
+		return http.ProxyFromEnvironment
+	}
+
+	return http.ProxyURL(proxyURL)
+}
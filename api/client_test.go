@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	rBody, _ = ioutil.ReadFile("../_testdata/agent_connect_response.json")
+)
+
+func newTestClient(url string) Client {
+	options := DefaultOptions(url, "ca9b8be2-c0e5-4b86-8fdc-b92d921597a0")
+	options.MinBackoff = 10 * time.Millisecond
+	options.MaxBackoff = 50 * time.Millisecond
+	return NewClient(options)
+}
+
+func TestShouldConnectWithRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	count := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		assert.Equal("ca9b8be2-c0e5-4b86-8fdc-b92d921597a0", r.Header.Get(util.HttpHeaderAgentToken))
+		_, _ = w.Write(rBody)
+	}))
+	defer ts.Close()
+
+	settings, err := newTestClient(ts.URL).Connect(&domain.AgentInit{Port: 8081})
+	assert.NoError(err)
+	assert.Equal(3, count)
+	assert.Equal("1", settings.Agent.ID)
+}
+
+func TestShouldNotRetryIfServerRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	count := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		_, _ = w.Write([]byte(`{"code": 400, "message": "invalid token"}`))
+	}))
+	defer ts.Close()
+
+	_, err := newTestClient(ts.URL).Connect(&domain.AgentInit{Port: 8081})
+	assert.Error(err)
+	assert.True(IsResponseError(err))
+	assert.Equal("invalid token", err.(*ResponseError).Message)
+	assert.Equal(1, count)
+}
+
+func TestShouldFailWhenServerUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	err := newTestClient(ts.URL).ReportProfile(&domain.Resource{Cpu: 1})
+	assert.Error(err)
+	assert.False(IsResponseError(err))
+}
+
+func TestShouldUploadLog(t *testing.T) {
+	assert := assert.New(t)
+
+	f, _ := ioutil.TempFile("", "agent_log_")
+	_, _ = f.WriteString("hello")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		assert.NoError(err)
+		assert.Equal(filepath.Base(f.Name())+".gz", header.Filename)
+		assert.Equal(util.HttpMimeGzip, header.Header.Get(util.HttpHeaderContentType))
+
+		reader, err := gzip.NewReader(file)
+		assert.NoError(err)
+
+		content, _ := ioutil.ReadAll(reader)
+		assert.Equal("hello", string(content))
+
+		_, _ = w.Write([]byte(`{"code": 200, "message": "ok"}`))
+	}))
+	defer ts.Close()
+
+	assert.NoError(newTestClient(ts.URL).UploadLog(f.Name()))
+}
+
+func TestShouldUploadLogInChunksAndResume(t *testing.T) {
+	assert := assert.New(t)
+
+	f, _ := ioutil.TempFile("", "agent_log_")
+	_, _ = f.WriteString("0123456789abcdef")
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	// server already received first 4 bytes, and failed once on next chunk
+	var received bytes.Buffer
+	received.WriteString("0123")
+	failed := false
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/agents/logs/upload/"+filepath.Base(f.Name()), r.URL.Path)
+
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"code": 200, "message": "ok", "data": %d}`, received.Len())))
+			return
+		}
+
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		assert.Equal(strconv.Itoa(received.Len()), r.URL.Query().Get("offset"))
+		assert.Equal("16", r.URL.Query().Get("total"))
+
+		reader, err := gzip.NewReader(r.Body)
+		assert.NoError(err)
+
+		content, _ := ioutil.ReadAll(reader)
+		received.Write(content)
+
+		_, _ = w.Write([]byte(`{"code": 200, "message": "ok"}`))
+	}))
+	defer ts.Close()
+
+	options := DefaultOptions(ts.URL, "token")
+	options.MinBackoff = 10 * time.Millisecond
+	options.ChunkSize = 5
+
+	assert.NoError(NewClient(options).UploadLog(f.Name()))
+	assert.Equal("0123456789abcdef", received.String())
+}
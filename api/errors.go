@@ -0,0 +1,26 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorServerUnavailable = errors.New("api: server not available")
+)
+
+// ResponseError the server has been reached but rejected the request
+type ResponseError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("api: server response with code %d: %s", e.Code, e.Message)
+}
+
+// IsResponseError check the error is returned from server, which should not be retried
+func IsResponseError(err error) bool {
+	_, ok := err.(*ResponseError)
+	return ok
+}
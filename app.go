@@ -7,6 +7,7 @@ import (
 	"github/flowci/flow-agent-x/controller"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/executor"
+	"github/flowci/flow-agent-x/service"
 	"github/flowci/flow-agent-x/util"
 	"net"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/urfave/cli"
@@ -68,6 +70,175 @@ func main() {
 			EnvVar:      domain.VarAgentVolumes,
 		},
 
+		cli.StringFlag{
+			Name:   "proxy",
+			Usage:  "Proxy for http requests to server, ex: http://127.0.0.1:3128",
+			EnvVar: domain.VarAgentProxy,
+		},
+
+		cli.DurationFlag{
+			Name:   "settings-interval",
+			Value:  5 * time.Minute,
+			Usage:  "Interval to reload settings from server, 0 to disable",
+			EnvVar: domain.VarAgentSettingsInterval,
+		},
+
+		cli.BoolFlag{
+			Name:   "verify-plugin",
+			Usage:  "Verify checksum of plugin with pinned version from server",
+			EnvVar: domain.VarAgentVerifyPlugin,
+		},
+
+		cli.StringFlag{
+			Name:   "workspace-mode",
+			Value:  domain.WorkspaceModeVolume,
+			Usage:  "Workspace of docker step, 'volume' to use agent volume at /ws, 'bind' to mount host job dir at the same path",
+			EnvVar: domain.VarAgentWorkspaceMode,
+		},
+
+		cli.BoolFlag{
+			Name:   "durable-queue",
+			Usage:  "Declare durable job queue to keep jobs on broker restart, should match the queue declared by server",
+			EnvVar: domain.VarAgentDurableQueue,
+		},
+
+		cli.StringFlag{
+			Name:   "labels",
+			Usage:  "Custom labels reported as agent tags, ex: --labels \"ios,xcode-10\"",
+			EnvVar: domain.VarAgentLabels,
+		},
+
+		cli.DurationFlag{
+			Name:   "health-interval",
+			Value:  30 * time.Second,
+			Usage:  "Interval of host checks, the agent stop accepting jobs if any check failed, 0 to disable",
+			EnvVar: domain.VarAgentHealthInterval,
+		},
+
+		cli.Uint64Flag{
+			Name:   "min-free-disk",
+			Value:  1024,
+			Usage:  "Min free disk of workspace in MB for host checks, 0 to skip",
+			EnvVar: domain.VarAgentMinFreeDisk,
+		},
+
+		cli.BoolFlag{
+			Name:   "check-docker",
+			Usage:  "Check docker daemon in host checks, enable it for agent running docker steps",
+			EnvVar: domain.VarAgentCheckDocker,
+		},
+
+		cli.DurationFlag{
+			Name:   "gc-interval",
+			Value:  1 * time.Hour,
+			Usage:  "Interval to clean workspace, logs, containers, images and plugins, 0 to disable",
+			EnvVar: domain.VarAgentGCInterval,
+		},
+
+		cli.DurationFlag{
+			Name:   "gc-max-age",
+			Value:  7 * 24 * time.Hour,
+			Usage:  "Max age of logs, stopped step containers and unused plugin versions",
+			EnvVar: domain.VarAgentGCMaxAge,
+		},
+
+		cli.DurationFlag{
+			Name:   "workspace-max-age",
+			Value:  7 * 24 * time.Hour,
+			Usage:  "Remove flow workspace which is not used within the duration, 0 to disable",
+			EnvVar: domain.VarAgentWorkspaceMaxAge,
+		},
+
+		cli.Int64Flag{
+			Name:   "workspace-max-size",
+			Usage:  "Max total size of flow workspaces in MB, the oldest will be removed, 0 to disable",
+			EnvVar: domain.VarAgentWorkspaceMaxSize,
+		},
+
+		cli.IntFlag{
+			Name:   "workspace-keep",
+			Usage:  "Keep N most recent flow workspaces, 0 to disable",
+			EnvVar: domain.VarAgentWorkspaceKeep,
+		},
+
+		cli.Int64Flag{
+			Name:   "outbox-max-size",
+			Value:  100,
+			Usage:  "Max size in MB of results and logs buffered on disk while server unavailable",
+			EnvVar: domain.VarAgentOutboxMaxSize,
+		},
+
+		cli.IntFlag{
+			Name:   "log-batch-size",
+			Value:  32 * 1024,
+			Usage:  "Max bytes of log content in one message",
+			EnvVar: domain.VarAgentLogBatchSize,
+		},
+
+		cli.DurationFlag{
+			Name:   "log-batch-interval",
+			Value:  500 * time.Millisecond,
+			Usage:  "Time window to batch log content before sending",
+			EnvVar: domain.VarAgentLogBatchInterval,
+		},
+
+		cli.BoolFlag{
+			Name:   "log-compress",
+			Usage:  "Compress log message by gzip",
+			EnvVar: domain.VarAgentLogCompress,
+		},
+
+		cli.IntFlag{
+			Name:   "log-buffer-size",
+			Value:  8,
+			Usage:  "Max size in MB of pending log in memory when the broker falls behind",
+			EnvVar: domain.VarAgentLogBufferSize,
+		},
+
+		cli.StringFlag{
+			Name:   "log-overflow",
+			Value:  domain.LogOverflowSpill,
+			Usage:  "Policy when log buffer is full, 'spill' to disk or 'drop'",
+			EnvVar: domain.VarAgentLogOverflow,
+		},
+
+		cli.BoolFlag{
+			Name:   "log-strip-ansi",
+			Usage:  "Remove ANSI colors and cursor codes from stored log file, the live log is not changed",
+			EnvVar: domain.VarAgentLogStripAnsi,
+		},
+
+		cli.Int64Flag{
+			Name:   "max-log-size",
+			Value:  100,
+			Usage:  "Max log size in MB of each cmd, the live log stopped and only head and tail kept in log file if exceeded, 0 to disable",
+			EnvVar: domain.VarAgentMaxLogSize,
+		},
+
+		cli.BoolFlag{
+			Name:   "kill-on-max-log-size",
+			Usage:  "Kill the cmd if log exceeds max log size",
+			EnvVar: domain.VarAgentKillOnMaxLogSize,
+		},
+
+		cli.StringFlag{
+			Name:   "pre-cmd",
+			Usage:  "Script file run before every cmd in the same shell",
+			EnvVar: domain.VarAgentPreCmdHook,
+		},
+
+		cli.StringFlag{
+			Name:   "post-cmd",
+			Usage:  "Script file run after every cmd in the same shell whatever the result",
+			EnvVar: domain.VarAgentPostCmdHook,
+		},
+
+		cli.StringFlag{
+			Name:   "on-failure",
+			Usage:  "Script file run after every failed cmd in the same shell",
+			EnvVar: domain.VarAgentOnFailureHook,
+		},
+
 		cli.StringFlag{
 			Name:  "script",
 			Value: "",
@@ -94,6 +265,32 @@ func start(c *cli.Context) error {
 	config.Server = c.String("url")
 	config.Token = c.String("token")
 	config.Port = getPort(c.String("port"))
+	config.Proxy = c.String("proxy")
+	config.SettingsInterval = c.Duration("settings-interval")
+	config.VerifyPlugin = c.Bool("verify-plugin")
+	config.WorkspaceMode = c.String("workspace-mode")
+	config.DurableQueue = c.Bool("durable-queue")
+	config.Labels = parseLabels(c.String("labels"))
+	config.HealthInterval = c.Duration("health-interval")
+	config.MinFreeDisk = c.Uint64("min-free-disk")
+	config.CheckDocker = c.Bool("check-docker")
+	config.GCInterval = c.Duration("gc-interval")
+	config.GCMaxAge = c.Duration("gc-max-age")
+	config.WorkspaceMaxAge = c.Duration("workspace-max-age")
+	config.WorkspaceMaxSize = c.Int64("workspace-max-size")
+	config.WorkspaceKeep = c.Int("workspace-keep")
+	config.OutboxMaxSize = c.Int64("outbox-max-size")
+	config.LogBatchSize = c.Int("log-batch-size")
+	config.LogBatchInterval = c.Duration("log-batch-interval")
+	config.LogCompress = c.Bool("log-compress")
+	config.LogBufferSize = c.Int("log-buffer-size")
+	config.LogOverflow = c.String("log-overflow")
+	config.LogStripAnsi = c.Bool("log-strip-ansi")
+	config.MaxLogSize = c.Int64("max-log-size")
+	config.KillOnMaxLogSize = c.Bool("kill-on-max-log-size")
+	config.PreCmdHook = util.ParseString(c.String("pre-cmd"))
+	config.PostCmdHook = util.ParseString(c.String("post-cmd"))
+	config.OnFailureHook = util.ParseString(c.String("on-failure"))
 	config.Workspace = util.ParseString(c.String("workspace"))
 	config.PluginDir = filepath.Join(config.Workspace, ".plugins")
 	config.LoggingDir = filepath.Join(config.Workspace, ".logs")
@@ -107,7 +304,12 @@ func start(c *cli.Context) error {
 	}
 
 	// connect to ci server
-	config.Init()
+	if err := config.Init(); err != nil {
+		return err
+	}
+	service.GetHealthService()
+	service.GetGCService()
+	service.GetOutboxService()
 	startGin(config)
 
 	return nil
@@ -163,6 +365,16 @@ func startGin(config *config.Manager) {
 	}
 }
 
+func parseLabels(str string) []string {
+	var labels []string
+	for _, label := range strings.Split(str, ",") {
+		if label = strings.TrimSpace(label); !util.IsEmptyString(label) {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
 func getPort(strPort string) int {
 	if util.IsEmptyString(strPort) {
 		listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/shirou/gopsutil/host"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	detectTimeout = 5 * time.Second
+)
+
+var (
+	versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+	// toolchain name and the commands to get version, the first available will be applied
+	toolchainCommands = []struct {
+		name     string
+		commands [][]string
+	}{
+		{name: "go", commands: [][]string{{"go", "version"}}},
+		{name: "java", commands: [][]string{{"java", "-version"}}},
+		{name: "node", commands: [][]string{{"node", "--version"}}},
+		{name: "python", commands: [][]string{{"python3", "--version"}, {"python", "--version"}}},
+	}
+
+	gpuDevices = []string{"/dev/nvidia0", "/dev/dri/renderD128"}
+)
+
+// detect capabilities of agent host, it's slow since external commands will be executed
+func (m *Manager) detectCapabilities() *domain.Capabilities {
+	c := &domain.Capabilities{
+		Arch:       runtime.GOARCH,
+		Toolchains: make(map[string]string),
+		Labels:     m.Labels,
+	}
+
+	c.Kernel, _ = host.KernelVersion()
+	c.Docker = detectDocker(m.AppCtx)
+	c.Gpu = detectGpu(m.AppCtx)
+
+	for _, toolchain := range toolchainCommands {
+		for _, args := range toolchain.commands {
+			if version, ok := detectVersion(m.AppCtx, args...); ok {
+				c.Toolchains[toolchain.name] = version
+				break
+			}
+		}
+	}
+
+	util.LogDebug("Agent capabilities: %+v", *c)
+	return c
+}
+
+func detectDocker(parent context.Context) *domain.DockerInfo {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return nil
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(parent, detectTimeout)
+	defer cancel()
+
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return &domain.DockerInfo{
+		Version:       info.ServerVersion,
+		StorageDriver: info.Driver,
+	}
+}
+
+func detectGpu(parent context.Context) bool {
+	for _, device := range gpuDevices {
+		if util.IsFileExists(device) {
+			return true
+		}
+	}
+
+	_, ok := detectVersion(parent, "nvidia-smi", "-L")
+	return ok
+}
+
+// run command and parse version from output, ex: 'go version go1.12.1 linux/amd64' to 1.12.1
+func detectVersion(parent context.Context, args ...string) (string, bool) {
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return util.EmptyStr, false
+	}
+
+	ctx, cancel := context.WithTimeout(parent, detectTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = os.Environ()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return util.EmptyStr, false
+	}
+
+	return parseVersion(string(output)), true
+}
+
+func parseVersion(output string) string {
+	return versionPattern.FindString(output)
+}
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldParseToolchainVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("1.12.1", parseVersion("go version go1.12.1 linux/amd64"))
+	assert.Equal("1.8.0", parseVersion("openjdk version \"1.8.0_212\"\nOpenJDK Runtime Environment"))
+	assert.Equal("10.15.0", parseVersion("v10.15.0\n"))
+	assert.Equal("", parseVersion("unknown"))
+}
@@ -4,4 +4,7 @@ import "errors"
 
 var (
 	ErrSettingsNotBeenLoaded = errors.New("agent: settings has not been initialized")
+	ErrMessageNotConfirmed   = errors.New("agent: message not confirmed by broker")
+	ErrConfirmTimeout        = errors.New("agent: timeout on waiting for broker confirm")
+	ErrMessageReturned       = errors.New("agent: message returned by broker since no queue bound")
 )
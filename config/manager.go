@@ -1,25 +1,39 @@
 package config
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/google/uuid"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/streadway/amqp"
+	"github/flowci/flow-agent-x/api"
+	"github/flowci/flow-agent-x/dao"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/util"
-	"io/ioutil"
-	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
+const (
+	connectMinBackoff = 1 * time.Second
+	connectMaxBackoff = 1 * time.Minute
+
+	// disk pressure if free disk less than 10% or 1GB
+	diskPressureRatio = 0.1
+	diskPressureMinMB = 1024
+
+	// max time to wait for broker confirm of publishing
+	publishConfirmTimeout = 30 * time.Second
+
+	dbFileName = "agent.db"
+)
+
 var (
 	singleton *Manager
 	once      sync.Once
@@ -31,17 +45,36 @@ type (
 		Channel    *amqp.Channel
 		LogChannel *amqp.Channel
 		JobQueue   *amqp.Queue
+
+		closed chan *amqp.Error
+
+		// publisher confirms, publishes on the same channel are serialized to match the delivery tag
+		mux         sync.Mutex
+		logMux      sync.Mutex
+		confirms    chan amqp.Confirmation
+		logConfirms chan amqp.Confirmation
+		returns     chan amqp.Return
+		tag         uint64
+		logTag      uint64
+
+		consumeMux  sync.Mutex
+		consumerTag string
 	}
 
 	// Manager to handle server connection and config
 	Manager struct {
+		mux sync.RWMutex
+
 		Settings *domain.Settings
 		Queue    *QueueConfig
 		Zk       *util.ZkClient
+		Client   api.Client
+		DB       *dao.Client
 
 		Server string
 		Token  string
 		Port   int
+		Proxy  string
 
 		Workspace  string
 		LoggingDir string
@@ -50,11 +83,133 @@ type (
 		VolumesStr string
 		Volumes    []*domain.DockerVolume
 
+		// workspace mode of docker step, volume or bind
+		WorkspaceMode string
+
+		// declare durable job queue, which should match the queue declared by server
+		DurableQueue bool
+
+		// custom labels reported as agent tags
+		Labels       []string
+		Capabilities *domain.Capabilities
+
+		// script file path of agent level hooks which run around every cmd
+		PreCmdHook    string
+		PostCmdHook   string
+		OnFailureHook string
+
+		// verify checksum of pinned plugin version from server
+		VerifyPlugin bool
+
+		// interval to reload settings from server, disabled if <= 0
+		SettingsInterval time.Duration
+
+		// interval of host checks, disabled if <= 0
+		HealthInterval time.Duration
+
+		// min free disk of workspace in MB for host checks
+		MinFreeDisk uint64
+
+		// check docker daemon in host checks
+		CheckDocker bool
+
+		// interval of disk gc, disabled if <= 0
+		GCInterval time.Duration
+
+		// max age of logs, stopped step containers and unused plugin versions
+		GCMaxAge time.Duration
+
+		// workspace retention policy of flow dirs, each is disabled if <= 0
+		WorkspaceMaxAge  time.Duration
+		WorkspaceMaxSize int64 // in MB
+		WorkspaceKeep    int   // keep N most recent flows
+
+		// log shipping, content is batched by size and time window, and gzip compressed if enabled
+		LogBatchSize     int // in bytes
+		LogBatchInterval time.Duration
+		LogCompress      bool
+		LogBufferSize    int    // max pending log in memory in MB
+		LogOverflow      string // drop or spill to disk if buffer is full
+
+		// remove ANSI escape sequence from log file, the live log is not changed
+		LogStripAnsi bool
+
+		// default max log size of cmd in MB, disabled if <= 0
+		MaxLogSize       int64
+		KillOnMaxLogSize bool
+
+		// max size of outbox in MB, which buffer results and logs while server unavailable
+		OutboxMaxSize int64
+
 		AppCtx context.Context
 		Cancel context.CancelFunc
+
+		// set by host checks, zero value is healthy
+		unhealthy bool
+
+		// cmd is running, set by cmd service
+		busy bool
 	}
 )
 
+// Close close channels and connection of rabbitmq
+func (qc *QueueConfig) Close() {
+	_ = qc.Channel.Close()
+	_ = qc.LogChannel.Close()
+	_ = qc.Conn.Close()
+}
+
+// IsClosed check connection been closed by server, network error or Close()
+func (qc *QueueConfig) IsClosed() bool {
+	select {
+	case <-qc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Publish message as mandatory and wait for broker confirm, ErrMessageReturned if message cannot be routed
+func (qc *QueueConfig) Publish(exchange, key string, msg amqp.Publishing) error {
+	qc.mux.Lock()
+	defer qc.mux.Unlock()
+
+	qc.tag++
+	return publishAndConfirm(qc.Channel, qc.confirms, qc.returns, qc.tag, exchange, key, msg)
+}
+
+// Consume jobs from job queue with manual ack, the delivery channel is closed once CancelConsume
+func (qc *QueueConfig) Consume(tag string) (<-chan amqp.Delivery, error) {
+	qc.consumeMux.Lock()
+	defer qc.consumeMux.Unlock()
+
+	qc.consumerTag = tag
+	return qc.Channel.Consume(qc.JobQueue.Name, tag, false, false, false, false, nil)
+}
+
+// CancelConsume stop delivering jobs, the delivered job can still be acked on channel
+func (qc *QueueConfig) CancelConsume() error {
+	qc.consumeMux.Lock()
+	defer qc.consumeMux.Unlock()
+
+	if util.IsEmptyString(qc.consumerTag) {
+		return nil
+	}
+
+	tag := qc.consumerTag
+	qc.consumerTag = ""
+	return qc.Channel.Cancel(tag, false)
+}
+
+// PublishLog publish message on log channel and wait for broker confirm
+func (qc *QueueConfig) PublishLog(exchange, key string, msg amqp.Publishing) error {
+	qc.logMux.Lock()
+	defer qc.logMux.Unlock()
+
+	qc.logTag++
+	return publishAndConfirm(qc.LogChannel, qc.logConfirms, nil, qc.logTag, exchange, key, msg)
+}
+
 // GetInstance get singleton of config manager
 func GetInstance() *Manager {
 	once.Do(func() {
@@ -63,7 +218,8 @@ func GetInstance() *Manager {
 	return singleton
 }
 
-func (m *Manager) Init() {
+// Init dirs, db and connections, the error returned if agent cannot connect to server
+func (m *Manager) Init() error {
 	// init dir
 	_ = os.MkdirAll(m.Workspace, os.ModePerm)
 	_ = os.MkdirAll(m.LoggingDir, os.ModePerm)
@@ -73,54 +229,178 @@ func (m *Manager) Init() {
 	m.AppCtx = ctx
 	m.Cancel = cancel
 
+	m.initDB()
+	m.initClient()
 	m.initVolumes()
-	m.loadSettings()
+	m.Capabilities = m.detectCapabilities()
+
+	if err := m.loadSettings(); err != nil {
+		return err
+	}
+
 	m.initRabbitMQ()
 	m.initZookeeper()
 	m.sendAgentProfile()
+	m.watchSettings()
+	return nil
 }
 
 // HasQueue has rabbit mq connected
 func (m *Manager) HasQueue() bool {
-	return m.Queue != nil
+	return m.GetQueue() != nil
 }
 
 // HasZookeeper has zookeeper connected
 func (m *Manager) HasZookeeper() bool {
-	return m.Zk != nil
+	return m.GetZk() != nil
+}
+
+// GetQueue current rabbitmq connection, which could be replaced on settings reload
+func (m *Manager) GetQueue() *QueueConfig {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.Queue
+}
+
+// GetZk current zookeeper client, which could be replaced on settings reload
+func (m *Manager) GetZk() *util.ZkClient {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.Zk
+}
+
+// SetBusy set by cmd service when cmd started or finished
+func (m *Manager) SetBusy(busy bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.busy = busy
+}
+
+// IsHealthy all host checks passed
+func (m *Manager) IsHealthy() bool {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return !m.unhealthy
+}
+
+// SetHealthy update health status and agent status on zookeeper if changed
+func (m *Manager) SetHealthy(healthy bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.unhealthy != healthy {
+		return
+	}
+
+	m.unhealthy = !healthy
+	m.updateZkStatus()
 }
 
 func (m *Manager) FetchProfile() *domain.Resource {
 	nCpu, _ := cpu.Counts(true)
 	vmStat, _ := mem.VirtualMemory()
-	diskStat, _ := disk.Usage("/")
+	diskStat, _ := disk.Usage(m.diskPath())
+
+	totalDisk := util.ByteToMB(diskStat.Total)
+	freeDisk := util.ByteToMB(diskStat.Free)
 
 	return &domain.Resource{
-		Cpu:         nCpu,
-		TotalMemory: util.ByteToMB(vmStat.Total),
-		FreeMemory:  util.ByteToMB(vmStat.Available),
-		TotalDisk:   util.ByteToMB(diskStat.Total),
-		FreeDisk:    util.ByteToMB(diskStat.Free),
+		Cpu:          nCpu,
+		TotalMemory:  util.ByteToMB(vmStat.Total),
+		FreeMemory:   util.ByteToMB(vmStat.Available),
+		TotalDisk:    totalDisk,
+		FreeDisk:     freeDisk,
+		DiskPressure: isDiskPressure(totalDisk, freeDisk),
+		Healthy:      m.IsHealthy(),
 	}
 }
 
 // Close release resources and connections
 func (m *Manager) Close() {
-	if m.HasQueue() {
-		_ = m.Queue.Channel.Close()
-		_ = m.Queue.LogChannel.Close()
-		_ = m.Queue.Conn.Close()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.Queue != nil {
+		m.Queue.Close()
 	}
 
-	if m.HasZookeeper() {
+	if m.Zk != nil {
 		m.Zk.Close()
 	}
+
+	if m.DB != nil {
+		m.DB.Close()
+	}
 }
 
 // --------------------------------
 //		Private Functions
 // --------------------------------
 
+// disk usage of workspace, or root if workspace not created
+func (m *Manager) diskPath() string {
+	if !util.IsEmptyString(m.Workspace) && util.IsFileExists(m.Workspace) {
+		return m.Workspace
+	}
+	return "/"
+}
+
+// status of agent on zk node, should be called with lock
+func (m *Manager) agentStatus() domain.AgentStatus {
+	if m.unhealthy {
+		return domain.AgentUnhealthy
+	}
+
+	if m.busy {
+		return domain.AgentBusy
+	}
+
+	return domain.AgentIdle
+}
+
+// set agent status on zk node, should be called with lock
+// the busy status is set by server on dispatching, so not changed while cmd is running
+func (m *Manager) updateZkStatus() {
+	if m.Zk == nil || m.Settings == nil {
+		return
+	}
+
+	status := m.agentStatus()
+	if status == domain.AgentBusy {
+		return
+	}
+
+	err := m.Zk.Set(getZkPath(m.Settings), string(status))
+	if !util.LogIfError(err) {
+		util.LogInfo("Agent status on zk been updated to %s", status)
+	}
+}
+
+// open sqlite db in workspace and migrate schema, the agent refuse to start if schema is newer
+func (m *Manager) initDB() {
+	if util.IsEmptyString(m.Workspace) {
+		util.LogWarn("Workspace not set, agent db is disabled")
+		return
+	}
+
+	db, err := dao.NewInstance(filepath.Join(m.Workspace, dbFileName))
+	util.PanicIfErr(err)
+
+	err = db.Migrate(dbEntities, dbMigrations)
+	if err != nil {
+		db.Close()
+		panic(err)
+	}
+
+	m.DB = db
+}
+
+func (m *Manager) initClient() {
+	options := api.DefaultOptions(m.Server, m.Token)
+	options.Proxy = m.Proxy
+	m.Client = api.NewClient(options)
+}
+
 func (m *Manager) initVolumes() {
 	if util.IsEmptyString(m.VolumesStr) {
 		return
@@ -144,34 +424,45 @@ func (m *Manager) initVolumes() {
 	}
 }
 
-func (m *Manager) loadSettings() {
-	uri := m.Server + "/agents/connect"
-	body, _ := json.Marshal(domain.AgentInit{
-		Port:     m.Port,
-		Os:       util.OS(),
-		Resource: m.FetchProfile(),
-	})
+func (m *Manager) agentInit() *domain.AgentInit {
+	init := &domain.AgentInit{
+		Port:         m.Port,
+		Os:           util.OS(),
+		Resource:     m.FetchProfile(),
+		Capabilities: m.Capabilities,
+	}
 
-	request, _ := http.NewRequest("POST", uri, bytes.NewBuffer(body))
-	request.Header.Set(util.HttpHeaderContentType, util.HttpMimeJson)
-	request.Header.Set(util.HttpHeaderAgentToken, m.Token)
+	if m.Capabilities != nil {
+		init.Tags = m.Capabilities.Tags(init.Os)
+	}
 
-	resp, errFromReq := http.DefaultClient.Do(request)
-	util.PanicIfErr(errFromReq)
+	return init
+}
 
-	defer resp.Body.Close()
-	raw, _ := ioutil.ReadAll(resp.Body)
+// load settings from server with retry, the error returned if server rejected the agent or app stopped
+func (m *Manager) loadSettings() error {
+	for attempt := 0; ; attempt++ {
+		settings, err := m.Client.Connect(m.agentInit())
+		if err == nil {
+			m.Settings = settings
+			util.LogDebug("Settings been loaded from server: \n%v", m.Settings)
+			return nil
+		}
 
-	var message domain.SettingsResponse
-	errFromJSON := json.Unmarshal(raw, &message)
-	util.PanicIfErr(errFromJSON)
+		// server rejected the agent, retry will not help
+		if api.IsResponseError(err) {
+			return err
+		}
 
-	if !message.IsOk() {
-		panic(fmt.Errorf(message.Message))
-	}
+		wait := util.Backoff(attempt, connectMinBackoff, connectMaxBackoff)
+		util.LogWarn("Unable to connect to server %s, retry in %s: %v", m.Server, wait, err)
 
-	m.Settings = message.Data
-	util.LogDebug("Settings been loaded from server: \n%v", m.Settings)
+		select {
+		case <-m.AppCtx.Done():
+			return m.AppCtx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
 func (m *Manager) initRabbitMQ() {
@@ -179,8 +470,41 @@ func (m *Manager) initRabbitMQ() {
 		panic(ErrSettingsNotBeenLoaded)
 	}
 
+	m.Queue = newQueueConfig(m.Settings, m.DurableQueue)
+}
+
+func (m *Manager) initZookeeper() {
+	if m.Settings == nil {
+		panic(ErrSettingsNotBeenLoaded)
+	}
+
+	m.Zk = newZkClient(m.Settings, m.agentStatus())
+}
+
+func (m *Manager) sendAgentProfile() {
+	ctx, cancel := context.WithCancel(m.AppCtx)
+
+	go func() {
+		defer cancel()
+
+		for {
+			select {
+			case <-ctx.Done(): // if cancel() execute
+				return
+			case <-time.After(1 * time.Minute):
+			}
+
+			err := m.Client.ReportProfile(m.FetchProfile())
+			if err != nil {
+				util.LogWarn("Unable to send agent profile: %v", err)
+			}
+		}
+	}()
+}
+
+func newQueueConfig(settings *domain.Settings, durable bool) *QueueConfig {
 	// get connection
-	connStr := m.Settings.Queue.GetConnectionString()
+	connStr := settings.Queue.GetConnectionString()
 	conn, err := amqp.Dial(connStr)
 	util.PanicIfErr(err)
 
@@ -197,21 +521,78 @@ func (m *Manager) initRabbitMQ() {
 	qc.Conn = conn
 	qc.Channel = ch
 	qc.LogChannel = logCh
+	qc.closed = conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	// enable publisher confirms
+	util.PanicIfErr(ch.Confirm(false))
+	util.PanicIfErr(logCh.Confirm(false))
+	qc.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 100))
+	qc.logConfirms = logCh.NotifyPublish(make(chan amqp.Confirmation, 100))
+	qc.returns = ch.NotifyReturn(make(chan amqp.Return, 10))
+
+	// jobs are acked manually, only one unacked job for the agent
+	util.PanicIfErr(ch.Qos(1, 0, false))
 
 	// init queue to receive job
-	jobQueue, err := ch.QueueDeclare(m.Settings.Agent.GetQueueName(), false, false, false, false, nil)
+	jobQueue, err := ch.QueueDeclare(settings.Agent.GetQueueName(), durable, false, false, false, nil)
 	util.PanicIfErr(err)
 
 	qc.JobQueue = &jobQueue
-	m.Queue = qc
+	return qc
 }
 
-func (m *Manager) initZookeeper() {
-	if m.Settings == nil {
-		panic(ErrSettingsNotBeenLoaded)
+// publish and wait for confirm of the delivery tag, confirms of previous timeout publishing are skipped.
+// the message is published as mandatory if returns channel provided, and the return comes before the confirm
+func publishAndConfirm(ch *amqp.Channel, confirms chan amqp.Confirmation, returns chan amqp.Return, tag uint64,
+	exchange, key string, msg amqp.Publishing) error {
+
+	mandatory := returns != nil
+	if mandatory {
+		msg.MessageId = uuid.New().String()
+	}
+
+	if err := ch.Publish(exchange, key, mandatory, false, msg); err != nil {
+		return err
 	}
 
-	zkConfig := m.Settings.Zookeeper
+	timeout := time.After(publishConfirmTimeout)
+	returned := false
+
+	for {
+		select {
+		case r := <-returns:
+			if r.MessageId == msg.MessageId {
+				returned = true
+			}
+
+		case confirm, ok := <-confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+
+			if confirm.DeliveryTag < tag {
+				continue
+			}
+
+			if !confirm.Ack {
+				return ErrMessageNotConfirmed
+			}
+
+			if returned {
+				return ErrMessageReturned
+			}
+
+			return nil
+
+		case <-timeout:
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+// connect to zookeeper and register agent node with status
+func newZkClient(settings *domain.Settings, status domain.AgentStatus) *util.ZkClient {
+	zkConfig := settings.Zookeeper
 
 	// make connection of zk
 	client := new(util.ZkClient)
@@ -220,48 +601,27 @@ func (m *Manager) initZookeeper() {
 		panic(err)
 	}
 
-	m.Zk = client
-
 	// register agent on zk
-	agentPath := getZkPath(m.Settings)
-	_, nodeErr := client.Create(agentPath, util.ZkNodeTypeEphemeral, string(domain.AgentIdle))
+	agentPath := getZkPath(settings)
+	_, nodeErr := client.Create(agentPath, util.ZkNodeTypeEphemeral, string(status))
 
 	if nodeErr != nil {
+		client.Close()
 		panic(nodeErr)
 	}
 
 	util.LogInfo("The zk node '%s' has been registered", agentPath)
+	return client
 }
 
-func (m *Manager) sendAgentProfile() {
-	uri := m.Server + "/agents/resource"
-	ctx, cancel := context.WithCancel(m.AppCtx)
-
-	go func() {
-		defer cancel()
-
-		for {
-			select {
-			case <-ctx.Done(): // if cancel() execute
-				return
-			default:
-				time.Sleep(1 * time.Minute)
-			}
-
-			body, err := json.Marshal(m.FetchProfile())
-			if err != nil {
-				continue
-			}
+func getZkPath(s *domain.Settings) string {
+	return s.Zookeeper.Root + "/" + s.Agent.ID
+}
 
-			request, _ := http.NewRequest("POST", uri, bytes.NewBuffer(body))
-			request.Header.Set(util.HttpHeaderContentType, util.HttpMimeJson)
-			request.Header.Set(util.HttpHeaderAgentToken, m.Token)
+func isDiskPressure(totalMB, freeMB uint64) bool {
+	if totalMB == 0 {
+		return false
+	}
 
-			_, _ = http.DefaultClient.Do(request)
-		}
-	}()
+	return freeMB < diskPressureMinMB || float64(freeMB) < float64(totalMB)*diskPressureRatio
 }
-
-func getZkPath(s *domain.Settings) string {
-	return s.Zookeeper.Root + "/" + s.Agent.ID
-}
\ No newline at end of file
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github/flowci/flow-agent-x/api"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,11 +45,18 @@ func TestShouldConnectServerAndGetSettings(t *testing.T) {
 	assert := assert.New(t)
 	defer ts.Close()
 
+	// agent db is created in workspace
+	dir, _ := ioutil.TempDir("", "agent_config_test_")
+	defer os.RemoveAll(dir)
+
 	m := GetInstance()
 	m.Server = ts.URL
 	m.Token = "ca9b8be2-c0e5-4b86-8fdc-b92d921597a0"
 	m.Port = 8081
-	m.Init()
+	m.Workspace = dir
+	m.LoggingDir = filepath.Join(dir, ".logs")
+	m.PluginDir = filepath.Join(dir, ".plugins")
+	assert.NoError(m.Init())
 	defer m.Close()
 
 	assert.NotNil(m.Settings)
@@ -52,3 +64,29 @@ func TestShouldConnectServerAndGetSettings(t *testing.T) {
 	assert.Equal("1", m.Settings.Agent.ID)
 	assert.Equal("xxx-xxx", m.Settings.Agent.Token)
 }
+
+func TestShouldDetectDiskPressure(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isDiskPressure(0, 0))
+	assert.False(isDiskPressure(100*1024, 50*1024))
+	assert.True(isDiskPressure(100*1024, 5*1024))
+	assert.True(isDiskPressure(5*1024, 512))
+}
+
+func TestShouldReturnErrorIfServerRejectedAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("forbidden"))
+	}))
+	defer server.Close()
+
+	m := &Manager{Server: server.URL, Token: "token", AppCtx: context.Background()}
+	m.initClient()
+
+	err := m.loadSettings()
+	assert.True(api.IsResponseError(err))
+	assert.Nil(m.Settings)
+}
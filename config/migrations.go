@@ -0,0 +1,16 @@
+package config
+
+import (
+	"github/flowci/flow-agent-x/dao"
+	"github/flowci/flow-agent-x/domain"
+)
+
+var (
+	// entities stored in agent db, the table and new columns are created on start
+	dbEntities = []interface{}{
+		domain.OutboxMessage{},
+	}
+
+	// ordered migrations of agent db, append only and the version must be increased
+	dbMigrations = []dao.Migration{}
+)
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	// wait for in-flight publishing on the previous connection before close it
+	queueCloseGracePeriod = 30 * time.Second
+)
+
+// ReloadSettings fetch settings from server and apply the changes on rabbitmq and zookeeper
+func (m *Manager) ReloadSettings() (out error) {
+	defer func() {
+		if err := recover(); err != nil {
+			if e, ok := err.(error); ok {
+				out = e
+				return
+			}
+			out = fmt.Errorf("%v", err)
+		}
+	}()
+
+	settings, err := m.Client.Connect(m.agentInit())
+	util.PanicIfErr(err)
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	current := m.Settings
+
+	if isQueueChanged(current, settings) || m.Queue == nil || m.Queue.IsClosed() {
+		util.LogInfo("RabbitMQ settings changed, reconnecting to %s", settings.Queue.Uri)
+
+		qc := newQueueConfig(settings, m.DurableQueue)
+		previous := m.Queue
+		m.Queue = qc
+
+		// stop consuming jobs from previous connection, the job consumer will re-subscribe on the new one,
+		// and the previous is closed after in-flight publishing and ack
+		if previous != nil {
+			util.LogIfError(previous.CancelConsume())
+			time.AfterFunc(queueCloseGracePeriod, previous.Close)
+		}
+	}
+
+	if isZookeeperChanged(current, settings) {
+		util.LogInfo("Zookeeper settings changed, registering to %s", settings.Zookeeper.Host)
+
+		// register with current status, so the running cmd is not dropped by server
+		zk := newZkClient(settings, m.agentStatus())
+		previous := m.Zk
+		m.Zk = zk
+
+		if previous != nil {
+			previous.Close()
+		}
+	}
+
+	m.Settings = settings
+	return
+}
+
+// reload settings from server periodically
+func (m *Manager) watchSettings() {
+	if m.SettingsInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-m.AppCtx.Done():
+				return
+			case <-time.After(m.SettingsInterval):
+			}
+
+			err := m.ReloadSettings()
+			if err != nil {
+				util.LogWarn("Unable to reload settings: %v", err)
+			}
+		}
+	}()
+}
+
+func isQueueChanged(current, settings *domain.Settings) bool {
+	if current == nil || current.Queue == nil {
+		return true
+	}
+
+	// callback and logs exchange are read on each publishing, only uri and job queue name need reconnect
+	return current.Queue.Uri != settings.Queue.Uri || current.Agent.ID != settings.Agent.ID
+}
+
+func isZookeeperChanged(current, settings *domain.Settings) bool {
+	if current == nil || current.Zookeeper == nil {
+		return true
+	}
+
+	return *current.Zookeeper != *settings.Zookeeper || current.Agent.ID != settings.Agent.ID
+}
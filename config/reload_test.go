@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github/flowci/flow-agent-x/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSettings() *domain.Settings {
+	return &domain.Settings{
+		Agent:     &domain.Agent{ID: "1"},
+		Queue:     &domain.RabbitMQConfig{Uri: "amqp://127.0.0.1:5672", Callback: "callback-q", LogsExchange: "logs"},
+		Zookeeper: &domain.ZookeeperConfig{Host: "127.0.0.1:2181", Root: "/flow-x"},
+	}
+}
+
+func TestShouldDetectQueueChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	current := newTestSettings()
+	assert.True(isQueueChanged(nil, current))
+
+	settings := newTestSettings()
+	settings.Queue.Callback = "callback-new"
+	assert.False(isQueueChanged(current, settings))
+
+	settings.Queue.Uri = "amqp://127.0.0.2:5672"
+	assert.True(isQueueChanged(current, settings))
+
+	settings = newTestSettings()
+	settings.Agent.ID = "2"
+	assert.True(isQueueChanged(current, settings))
+}
+
+func TestShouldDetectZookeeperChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	current := newTestSettings()
+	assert.False(isZookeeperChanged(current, newTestSettings()))
+
+	settings := newTestSettings()
+	settings.Zookeeper.Root = "/flow-y"
+	assert.True(isZookeeperChanged(current, settings))
+}
+
+func TestShouldRegisterWithCurrentStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &Manager{}
+	assert.Equal(domain.AgentIdle, m.agentStatus())
+
+	m.SetBusy(true)
+	assert.Equal(domain.AgentBusy, m.agentStatus())
+
+	m.unhealthy = true
+	assert.Equal(domain.AgentUnhealthy, m.agentStatus())
+}
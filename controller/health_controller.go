@@ -5,6 +5,10 @@ import (
 	"runtime"
 
 	"github.com/gin-gonic/gin"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/service"
 )
 
 type HealthController struct {
@@ -14,8 +18,11 @@ type HealthController struct {
 }
 
 type HealthInfo struct {
-	CPU    int              `json:"cpu"`
-	Memory runtime.MemStats `json:"memory"`
+	CPU     int                   `json:"cpu"`
+	Memory  runtime.MemStats      `json:"memory"`
+	Healthy bool                  `json:"healthy"`
+	Checks  []*domain.HealthCheck `json:"checks"`
+	Outbox  domain.OutboxStats    `json:"outbox"`
 }
 
 // NewHealthController create new instance of HealthController
@@ -30,8 +37,16 @@ func (c *HealthController) GetInfoImpl(context *gin.Context) {
 	runtime.ReadMemStats(&mem)
 
 	info := HealthInfo{
-		CPU:    runtime.NumCPU(),
-		Memory: mem,
+		CPU:     runtime.NumCPU(),
+		Memory:  mem,
+		Healthy: config.GetInstance().IsHealthy(),
+		Checks:  service.GetHealthService().Checks(),
+		Outbox:  service.GetOutboxService().Stats(),
+	}
+
+	if !info.Healthy {
+		context.JSON(http.StatusServiceUnavailable, info)
+		return
 	}
 
 	context.JSON(http.StatusOK, info)
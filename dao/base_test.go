@@ -9,3 +9,23 @@ type MockSubEntity struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+type MockNested struct {
+	Key   string
+	Items []string
+}
+
+type MockTypedEntity struct {
+	ID        string            `db:"column=id,pk=true,nullable=false"`
+	Name      string            `db:"unique=true"`
+	Count     int64             `db:"index=true"`
+	Size      uint32            `db:"column=size"`
+	Ratio     float64           `db:"column=ratio"`
+	Enabled   bool              `db:"column=enabled"`
+	Raw       []byte            `db:"column=raw"`
+	Labels    map[string]string `db:"column=labels"`
+	Nested    MockNested        `db:"column=nested"`
+	CreatedAt time.Time         `db:"column=created_at"`
+	UpdatedAt time.Time         `db:"column=updated_at,time=rfc3339"`
+	Ignored   string
+}
@@ -1,39 +1,51 @@
 package dao
 
 import (
-	"fmt"
+	"database/sql"
 	"reflect"
 	"strings"
 
 	u "github/flowci/flow-agent-x/util"
 )
 
-type QueryBuilder struct {
-	entity     interface{}
-	entityType reflect.Type
+type (
+	QueryBuilder struct {
+		entity     interface{}
+		entityType reflect.Type
 
-	table   string
-	columns []*EntityColumn
-	key     *EntityColumn
-}
+		table   string
+		columns []*EntityColumn
+		key     *EntityColumn
+	}
+
+	// Query condition of find where, the where clause should use '?' as placeholder of args
+	Query struct {
+		Where   string
+		Args    []interface{}
+		OrderBy string // column name
+		Desc    bool
+		Page    int // start from 0
+		Size    int // no paging if <= 0
+	}
+)
 
 // init querybuilder with metadata
 func initQueryBuilder(entity interface{}) *QueryBuilder {
-	t := u.GetType(entity)
+	builder := newQueryBuilder(u.GetType(entity))
+	builder.entity = entity
+	return builder
+}
 
+func newQueryBuilder(t reflect.Type) *QueryBuilder {
 	builder := new(QueryBuilder)
 	builder.entityType = t
-	builder.entity = entity
 	builder.table = flatCamelString(t.Name())
-	builder.columns = make([]*EntityColumn, t.NumField())
-
-	numOfNil := 0
+	builder.columns = make([]*EntityColumn, 0, t.NumField())
 
 	for i := 0; i < t.NumField(); i++ {
 		column := parseEntityColumn(t.Field(i))
 
 		if column == nil {
-			numOfNil++
 			continue
 		}
 
@@ -41,10 +53,9 @@ func initQueryBuilder(entity interface{}) *QueryBuilder {
 			builder.key = column
 		}
 
-		builder.columns[i-numOfNil] = column
+		builder.columns = append(builder.columns, column)
 	}
 
-	builder.columns = builder.columns[:numOfNil+1]
 	return builder
 }
 
@@ -76,87 +87,216 @@ func (builder *QueryBuilder) create() (string, error) {
 	return sql.String(), nil
 }
 
+// create index for columns with 'index' or 'unique' tag
+func (builder *QueryBuilder) indexes() []string {
+	var queries []string
+	for _, c := range builder.columns {
+		if q := c.toIndexQuery(builder.table); !u.IsEmptyString(q) {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
 func (builder *QueryBuilder) drop() (string, error) {
 	return "DROP TABLE IF EXISTS " + builder.table + ";", nil
 }
 
-func (builder *QueryBuilder) insert(data interface{}) (string, error) {
+func (builder *QueryBuilder) insert(data interface{}) (string, []interface{}, error) {
 	if !isSameType(builder.entityType, data) {
-		return "", ErrorNotEntity
+		return u.EmptyStr, nil, ErrorNotEntity
+	}
+
+	args, err := builder.values(data, builder.columns)
+	if u.HasError(err) {
+		return u.EmptyStr, nil, err
 	}
 
 	var sql strings.Builder
 	sql.WriteString("INSERT INTO ")
 	sql.WriteString(builder.table)
 	sql.WriteString(" (")
+	sql.WriteString(builder.columnList())
+	sql.WriteString(") VALUES (")
+	sql.WriteString(placeholders(len(builder.columns)))
+	sql.WriteString(");")
 
-	for i, c := range builder.columns {
-		sql.WriteString("'" + c.Column + "'")
+	return sql.String(), args, nil
+}
 
-		if i < len(builder.columns)-1 {
-			sql.WriteString(",")
-		}
+// update all columns except primary key
+func (builder *QueryBuilder) update(data interface{}) (string, []interface{}, error) {
+	if !isSameType(builder.entityType, data) {
+		return u.EmptyStr, nil, ErrorNotEntity
 	}
 
-	sql.WriteString(")")
-	sql.WriteString(" VALUES ")
-	sql.WriteString("(")
+	if builder.key == nil {
+		return u.EmptyStr, nil, ErrorPrimaryKeyMissing
+	}
 
-	value := u.GetValue(data)
-	for i, c := range builder.columns {
-		query, err := toString(value.FieldByName(c.Field.Name))
-		if u.HasError(err) {
-			return u.EmptyStr, err
+	columns := make([]*EntityColumn, 0, len(builder.columns))
+	for _, c := range builder.columns {
+		if c != builder.key {
+			columns = append(columns, c)
 		}
+	}
 
-		sql.WriteString(query)
+	args, err := builder.values(data, append(columns, builder.key))
+	if u.HasError(err) {
+		return u.EmptyStr, nil, err
+	}
 
-		if i < len(builder.columns)-1 {
+	var sql strings.Builder
+	sql.WriteString("UPDATE " + builder.table + " SET ")
+
+	for i, c := range columns {
+		if i > 0 {
 			sql.WriteString(",")
 		}
+
+		sql.WriteString(c.Column + "=?")
 	}
 
-	sql.WriteString(");")
+	sql.WriteString(" WHERE " + builder.key.Column + "=?;")
+	return sql.String(), args, nil
+}
 
-	return sql.String(), nil
+func (builder *QueryBuilder) delete(id interface{}) (string, []interface{}, error) {
+	if builder.key == nil {
+		return u.EmptyStr, nil, ErrorPrimaryKeyMissing
+	}
+
+	sql := "DELETE FROM " + builder.table + " WHERE " + builder.key.Column + "=?;"
+	return sql, []interface{}{id}, nil
 }
 
-func (builder *QueryBuilder) find(id string) (string, error) {
+func (builder *QueryBuilder) find(id interface{}) (string, []interface{}, error) {
+	if builder.key == nil {
+		return u.EmptyStr, nil, ErrorPrimaryKeyMissing
+	}
+
+	sql := "SELECT " + builder.columnList() + " FROM " + builder.table + " WHERE " + builder.key.Column + "=?;"
+	return sql, []interface{}{id}, nil
+}
+
+func (builder *QueryBuilder) findWhere(query Query) (string, []interface{}, error) {
 	var sql strings.Builder
-	sql.WriteString("SELECT ")
+	sql.WriteString("SELECT " + builder.columnList() + " FROM " + builder.table)
 
-	for i, c := range builder.columns {
-		sql.WriteString("'" + c.Column + "'")
+	args := make([]interface{}, 0, len(query.Args)+2)
 
-		if i < len(builder.columns)-1 {
-			sql.WriteString(",")
+	if !u.IsEmptyString(query.Where) {
+		sql.WriteString(" WHERE " + query.Where)
+		args = append(args, query.Args...)
+	}
+
+	// order by column name only, since it cannot be bound as parameter
+	if !u.IsEmptyString(query.OrderBy) {
+		if builder.column(query.OrderBy) == nil {
+			return u.EmptyStr, nil, ErrorUnknownColumn
+		}
+
+		sql.WriteString(" ORDER BY " + query.OrderBy)
+
+		if query.Desc {
+			sql.WriteString(" DESC")
 		}
 	}
 
-	sql.WriteString(" FROM " + builder.table)
-	sql.WriteString(fmt.Sprintf(" WHERE %s='%s';", builder.key.Column, id))
+	if query.Size > 0 {
+		sql.WriteString(" LIMIT ? OFFSET ?")
+		args = append(args, query.Size, query.Page*query.Size)
+	}
 
-	return sql.String(), nil
+	sql.WriteString(";")
+	return sql.String(), args, nil
 }
 
-func isSameType(source reflect.Type, data interface{}) bool {
-	t := u.GetType(data)
-	return t == source
+// aggregate function on column or '*' with where clause of query, order and paging are ignored
+func (builder *QueryBuilder) aggregate(fn, column string, query Query) (string, []interface{}, error) {
+	if column != "*" && builder.column(column) == nil {
+		return u.EmptyStr, nil, ErrorUnknownColumn
+	}
+
+	sql := "SELECT IFNULL(" + fn + "(" + column + "),0) FROM " + builder.table
+
+	if u.IsEmptyString(query.Where) {
+		return sql + ";", nil, nil
+	}
+
+	return sql + " WHERE " + query.Where + ";", query.Args, nil
 }
 
-// from value to sql type
-func toString(val reflect.Value) (string, error) {
-	if val.Kind() == reflect.String {
-		return "'" + val.String() + "'", nil
+// scan current row to new entity value
+func (builder *QueryBuilder) scan(rows *sql.Rows) (reflect.Value, error) {
+	holders := make([]interface{}, len(builder.columns))
+	for i := range holders {
+		holders[i] = new(interface{})
 	}
 
-	if val.Kind() == reflect.Bool {
-		return fmt.Sprintf("%t", val.Bool()), nil
+	entity := reflect.New(builder.entityType).Elem()
+
+	if err := rows.Scan(holders...); u.HasError(err) {
+		return entity, err
 	}
 
-	if val.Kind() == reflect.Int {
-		return fmt.Sprintf("%d", val.Int()), nil
+	for i, c := range builder.columns {
+		src := *(holders[i].(*interface{}))
+
+		if err := c.fromValue(entity.FieldByIndex(c.Field.Index), src); u.HasError(err) {
+			return entity, err
+		}
 	}
 
-	return u.EmptyStr, ErrorUnsupporttedDataType
+	return entity, nil
+}
+
+func (builder *QueryBuilder) keyValue(data interface{}) (interface{}, error) {
+	if builder.key == nil {
+		return nil, ErrorPrimaryKeyMissing
+	}
+
+	return builder.key.toValue(u.GetValue(data).FieldByIndex(builder.key.Field.Index))
+}
+
+func (builder *QueryBuilder) values(data interface{}, columns []*EntityColumn) ([]interface{}, error) {
+	value := u.GetValue(data)
+	args := make([]interface{}, len(columns))
+
+	for i, c := range columns {
+		arg, err := c.toValue(value.FieldByIndex(c.Field.Index))
+		if u.HasError(err) {
+			return nil, err
+		}
+
+		args[i] = arg
+	}
+
+	return args, nil
+}
+
+func (builder *QueryBuilder) column(name string) *EntityColumn {
+	for _, c := range builder.columns {
+		if c.Column == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (builder *QueryBuilder) columnList() string {
+	names := make([]string, len(builder.columns))
+	for i, c := range builder.columns {
+		names[i] = c.Column
+	}
+	return strings.Join(names, ",")
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func isSameType(source reflect.Type, data interface{}) bool {
+	t := u.GetType(data)
+	return t == source
 }
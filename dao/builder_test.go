@@ -40,18 +40,59 @@ func TestShouldBuildQueryForInsert(t *testing.T) {
 	}
 
 	builder := initQueryBuilder(MockSubEntity{})
-	query, _ := builder.insert(entity)
+	query, args, _ := builder.insert(entity)
 
-	expected := "INSERT INTO mock_sub_entity ('id','name','age') VALUES ('12345','yang',18);"
+	expected := "INSERT INTO mock_sub_entity (id,name,age) VALUES (?,?,?);"
 	assert.Equal(expected, query)
+	assert.Equal([]interface{}{"12345", "yang", int64(18)}, args)
+}
+
+func TestShouldBuildQueryForUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	entity := &MockSubEntity{
+		ID:   "12345",
+		Name: "yang",
+		Age:  18,
+	}
+
+	builder := initQueryBuilder(MockSubEntity{})
+	query, args, _ := builder.update(entity)
+
+	expected := "UPDATE mock_sub_entity SET name=?,age=? WHERE id=?;"
+	assert.Equal(expected, query)
+	assert.Equal([]interface{}{"yang", int64(18), "12345"}, args)
 }
 
 func TestShouldBuildQueryForFindByID(t *testing.T) {
 	assert := assert.New(t)
 
 	builder := initQueryBuilder(MockSubEntity{})
-	query, _ := builder.find("12345")
+	query, args, _ := builder.find("12345")
 
-	expected := "SELECT 'id','name','age' FROM mock_sub_entity WHERE id='12345';"
+	expected := "SELECT id,name,age FROM mock_sub_entity WHERE id=?;"
 	assert.Equal(expected, query)
+	assert.Equal([]interface{}{"12345"}, args)
+}
+
+func TestShouldBuildQueryForFindWhereWithPaging(t *testing.T) {
+	assert := assert.New(t)
+
+	builder := initQueryBuilder(MockSubEntity{})
+	query, args, err := builder.findWhere(Query{
+		Where:   "age>?",
+		Args:    []interface{}{10},
+		OrderBy: "age",
+		Desc:    true,
+		Page:    2,
+		Size:    5,
+	})
+	assert.Nil(err)
+
+	expected := "SELECT id,name,age FROM mock_sub_entity WHERE age>? ORDER BY age DESC LIMIT ? OFFSET ?;"
+	assert.Equal(expected, query)
+	assert.Equal([]interface{}{10, 5, 10}, args)
+
+	_, _, err = builder.findWhere(Query{OrderBy: "age;DROP TABLE x"})
+	assert.Equal(ErrorUnknownColumn, err)
 }
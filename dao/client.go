@@ -2,6 +2,7 @@ package dao
 
 import (
 	"database/sql"
+	"reflect"
 
 	"github/flowci/flow-agent-x/util"
 
@@ -40,15 +41,196 @@ func (c *Client) Close() {
 }
 
 func (c *Client) Create(entity interface{}) error {
-	builder := QueryBuilder{
-		entity: entity,
-	}
+	builder := initQueryBuilder(entity)
 
 	sqlStmt, err := builder.create()
 	if util.HasError(err) {
 		return err
 	}
 
-	_, err = c.db.Exec(sqlStmt)
+	if _, err = c.db.Exec(sqlStmt); util.HasError(err) {
+		return err
+	}
+
+	for _, q := range builder.indexes() {
+		if _, err = c.db.Exec(q); util.HasError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save insert entity as new row
+func (c *Client) Save(entity interface{}) error {
+	builder := initQueryBuilder(entity)
+
+	sqlStmt, args, err := builder.insert(entity)
+	if util.HasError(err) {
+		return err
+	}
+
+	_, err = c.db.Exec(sqlStmt, args...)
 	return err
 }
+
+// Update all columns of entity by primary key
+func (c *Client) Update(entity interface{}) error {
+	builder := initQueryBuilder(entity)
+
+	sqlStmt, args, err := builder.update(entity)
+	if util.HasError(err) {
+		return err
+	}
+
+	return c.execOnRow(sqlStmt, args)
+}
+
+// Delete entity by primary key
+func (c *Client) Delete(entity interface{}) error {
+	builder := initQueryBuilder(entity)
+
+	id, err := builder.keyValue(entity)
+	if util.HasError(err) {
+		return err
+	}
+
+	sqlStmt, args, err := builder.delete(id)
+	if util.HasError(err) {
+		return err
+	}
+
+	return c.execOnRow(sqlStmt, args)
+}
+
+// FindByID load entity to out which is pointer of entity, ErrorEntityNotFound returned if not found
+func (c *Client) FindByID(out interface{}, id interface{}) error {
+	if !util.IsPointerType(out) {
+		return ErrorInvalidOutput
+	}
+
+	builder := initQueryBuilder(out)
+
+	sqlStmt, args, err := builder.find(id)
+	if util.HasError(err) {
+		return err
+	}
+
+	rows, err := c.db.Query(sqlStmt, args...)
+	if util.HasError(err) {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); util.HasError(err) {
+			return err
+		}
+		return ErrorEntityNotFound
+	}
+
+	entity, err := builder.scan(rows)
+	if util.HasError(err) {
+		return err
+	}
+
+	util.GetValue(out).Set(entity)
+	return nil
+}
+
+// FindWhere load entities to out which is pointer of entity slice, ex: *[]Entity or *[]*Entity
+func (c *Client) FindWhere(out interface{}, query Query) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return ErrorInvalidOutput
+	}
+
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	if elemType.Kind() != reflect.Struct {
+		return ErrorInvalidOutput
+	}
+
+	builder := newQueryBuilder(elemType)
+
+	sqlStmt, args, err := builder.findWhere(query)
+	if util.HasError(err) {
+		return err
+	}
+
+	rows, err := c.db.Query(sqlStmt, args...)
+	if util.HasError(err) {
+		return err
+	}
+	defer rows.Close()
+
+	result := reflect.MakeSlice(slice.Type(), 0, 0)
+
+	for rows.Next() {
+		entity, err := builder.scan(rows)
+		if util.HasError(err) {
+			return err
+		}
+
+		if isPtr {
+			ptr := reflect.New(elemType)
+			ptr.Elem().Set(entity)
+			entity = ptr
+		}
+
+		result = reflect.Append(result, entity)
+	}
+
+	if err = rows.Err(); util.HasError(err) {
+		return err
+	}
+
+	slice.Set(result)
+	return nil
+}
+
+// Count rows of entity by where clause of query
+func (c *Client) Count(entity interface{}, query Query) (int64, error) {
+	return c.aggregate(entity, "COUNT", "*", query)
+}
+
+// Sum values of column by where clause of query
+func (c *Client) Sum(entity interface{}, column string, query Query) (int64, error) {
+	return c.aggregate(entity, "SUM", column, query)
+}
+
+func (c *Client) aggregate(entity interface{}, fn, column string, query Query) (int64, error) {
+	sqlStmt, args, err := initQueryBuilder(entity).aggregate(fn, column, query)
+	if util.HasError(err) {
+		return 0, err
+	}
+
+	var result int64
+	err = c.db.QueryRow(sqlStmt, args...).Scan(&result)
+	return result, err
+}
+
+// exec sql which should affect one row, ErrorEntityNotFound returned if nothing changed
+func (c *Client) execOnRow(sqlStmt string, args []interface{}) error {
+	result, err := c.db.Exec(sqlStmt, args...)
+	if util.HasError(err) {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if util.HasError(err) {
+		return err
+	}
+
+	if n == 0 {
+		return ErrorEntityNotFound
+	}
+
+	return nil
+}
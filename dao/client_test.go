@@ -3,7 +3,9 @@ package dao
 import (
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,13 +16,140 @@ func TestShouldCreateTable(t *testing.T) {
 	dir, _ := ioutil.TempDir("", "t")
 	defer os.RemoveAll(dir)
 
-	// dbPath := path.Join(dir, "test.db")
+	dbPath := path.Join(dir, "test.db")
 
-	client, err := NewInstance("/Users/yang/test.db")
+	client, err := NewInstance(dbPath)
 	assert.Nil(err)
 	assert.NotNil(client)
+	defer client.Close()
 
 	entity := &MockSubEntity{}
 	err = client.Create(entity)
 	assert.Nil(err)
 }
+
+func TestShouldSaveUpdateAndDeleteEntity(t *testing.T) {
+	assert := assert.New(t)
+	client, dir := createTestClient(assert)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	// when: save entity with injection like value
+	entity := &MockSubEntity{ID: "1", Name: "yang'); DROP TABLE mock_sub_entity; --", Age: 18}
+	assert.Nil(client.Save(entity))
+
+	// then:
+	loaded := new(MockSubEntity)
+	assert.Nil(client.FindByID(loaded, "1"))
+	assert.Equal(entity.Name, loaded.Name)
+	assert.Equal(18, loaded.Age)
+
+	// when: update
+	entity.Age = 20
+	assert.Nil(client.Update(entity))
+
+	// then:
+	assert.Nil(client.FindByID(loaded, "1"))
+	assert.Equal(20, loaded.Age)
+
+	// when: delete
+	assert.Nil(client.Delete(entity))
+
+	// then:
+	assert.Equal(ErrorEntityNotFound, client.FindByID(loaded, "1"))
+	assert.Equal(ErrorEntityNotFound, client.Delete(entity))
+}
+
+func TestShouldFindWhereWithPaging(t *testing.T) {
+	assert := assert.New(t)
+	client, dir := createTestClient(assert)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(client.Save(&MockSubEntity{ID: name, Name: name, Age: i}))
+	}
+
+	var list []MockSubEntity
+	err := client.FindWhere(&list, Query{Where: "age>=?", Args: []interface{}{1}, OrderBy: "age", Page: 1, Size: 2})
+	assert.Nil(err)
+	assert.Equal(2, len(list))
+	assert.Equal("d", list[0].ID)
+	assert.Equal("e", list[1].ID)
+
+	var all []*MockSubEntity
+	assert.Nil(client.FindWhere(&all, Query{OrderBy: "age", Desc: true}))
+	assert.Equal(5, len(all))
+	assert.Equal("e", all[0].Name)
+
+	assert.Equal(ErrorInvalidOutput, client.FindWhere(list, Query{}))
+
+	count, err := client.Count(MockSubEntity{}, Query{Where: "age>?", Args: []interface{}{2}})
+	assert.Nil(err)
+	assert.Equal(int64(2), count)
+
+	sum, err := client.Sum(MockSubEntity{}, "age", Query{})
+	assert.Nil(err)
+	assert.Equal(int64(10), sum)
+
+	_, err = client.Sum(MockSubEntity{}, "age);--", Query{})
+	assert.Equal(ErrorUnknownColumn, err)
+}
+
+func TestShouldSaveAndLoadTypedEntity(t *testing.T) {
+	assert := assert.New(t)
+	client, dir := createTestClient(assert)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	assert.Nil(client.Create(&MockTypedEntity{}))
+
+	now := time.Now()
+	entity := &MockTypedEntity{
+		ID:        "1",
+		Name:      "typed",
+		Count:     1 << 40,
+		Size:      42,
+		Ratio:     0.5,
+		Enabled:   true,
+		Raw:       []byte{0, 1, 2},
+		Labels:    map[string]string{"os": "linux"},
+		Nested:    MockNested{Key: "key", Items: []string{"a", "b"}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	assert.Nil(client.Save(entity))
+
+	loaded := new(MockTypedEntity)
+	assert.Nil(client.FindByID(loaded, "1"))
+	assert.Equal(entity.Count, loaded.Count)
+	assert.Equal(entity.Size, loaded.Size)
+	assert.Equal(entity.Ratio, loaded.Ratio)
+	assert.True(loaded.Enabled)
+	assert.Equal(entity.Raw, loaded.Raw)
+	assert.Equal(entity.Labels, loaded.Labels)
+	assert.Equal(entity.Nested, loaded.Nested)
+	assert.Equal(now.UnixNano()/int64(time.Millisecond), loaded.CreatedAt.UnixNano()/int64(time.Millisecond))
+	assert.True(now.Equal(loaded.UpdatedAt))
+
+	// then: unique index
+	entity.ID = "2"
+	assert.NotNil(client.Save(entity))
+
+	// then: zero time stored as null
+	entity.Name = "zero"
+	entity.CreatedAt = time.Time{}
+	assert.Nil(client.Save(entity))
+	assert.Nil(client.FindByID(loaded, "2"))
+	assert.True(loaded.CreatedAt.IsZero())
+}
+
+func createTestClient(assert *assert.Assertions) (*Client, string) {
+	dir, _ := ioutil.TempDir("", "t")
+
+	client, err := NewInstance(path.Join(dir, "test.db"))
+	assert.Nil(err)
+	assert.Nil(client.Create(&MockSubEntity{}))
+
+	return client, dir
+}
@@ -1,9 +1,12 @@
 package dao
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github/flowci/flow-agent-x/util"
 )
@@ -17,11 +20,36 @@ const (
 	//keyFieldNullable = "nullable"
 )
 
+const (
+	TimeFormatUnixMs  = "unix_ms"
+	TimeFormatRFC3339 = "rfc3339"
+)
+
 var (
 	typeMapping = map[reflect.Kind]string{
-		reflect.Int:    "INTEGER",
-		reflect.String: "TEXT",
+		reflect.Int:     "INTEGER",
+		reflect.Int8:    "INTEGER",
+		reflect.Int16:   "INTEGER",
+		reflect.Int32:   "INTEGER",
+		reflect.Int64:   "INTEGER",
+		reflect.Uint:    "INTEGER",
+		reflect.Uint8:   "INTEGER",
+		reflect.Uint16:  "INTEGER",
+		reflect.Uint32:  "INTEGER",
+		reflect.Uint64:  "INTEGER",
+		reflect.Bool:    "INTEGER",
+		reflect.Float32: "REAL",
+		reflect.Float64: "REAL",
+		reflect.String:  "TEXT",
+
+		// serialized as json
+		reflect.Map:    "TEXT",
+		reflect.Slice:  "TEXT",
+		reflect.Struct: "TEXT",
 	}
+
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte{})
 )
 
 type EntityColumn struct {
@@ -29,10 +57,13 @@ type EntityColumn struct {
 	Column   string
 	Nullable bool
 	Pk       bool
+	Index    bool
+	Unique   bool
+	Time     string // format of time.Time, unix_ms as default
 }
 
 func (f *EntityColumn) toQuery() (string, error) {
-	t := typeMapping[f.Field.Type.Kind()]
+	t := f.sqlType()
 
 	if util.IsEmptyString(t) {
 		return util.EmptyStr, ErrorDBTypeNotAvailable
@@ -59,6 +90,177 @@ func (f *EntityColumn) toQuery() (string, error) {
 	return query.String(), nil
 }
 
+// column definition for 'ALTER TABLE ADD COLUMN', which is always nullable since existing rows have no value
+func (f *EntityColumn) toAddColumnQuery() (string, error) {
+	t := f.sqlType()
+
+	if util.IsEmptyString(t) {
+		return util.EmptyStr, ErrorDBTypeNotAvailable
+	}
+
+	return f.Column + " " + t, nil
+}
+
+// index created by 'index' or 'unique' tag, unique index used since unique column cannot be added by alter table
+func (f *EntityColumn) toIndexQuery(table string) string {
+	if f.Unique {
+		return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS uk_%s_%s ON %s (%s);", table, f.Column, table, f.Column)
+	}
+
+	if f.Index {
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s (%s);", table, f.Column, table, f.Column)
+	}
+
+	return util.EmptyStr
+}
+
+func (f *EntityColumn) sqlType() string {
+	t := f.Field.Type
+
+	if t == timeType {
+		if f.Time == TimeFormatRFC3339 {
+			return "TEXT"
+		}
+		return "INTEGER"
+	}
+
+	if t == bytesType {
+		return "BLOB"
+	}
+
+	return typeMapping[t.Kind()]
+}
+
+// from field value to sql parameter
+func (f *EntityColumn) toValue(val reflect.Value) (interface{}, error) {
+	if val.Type() == timeType {
+		t := val.Interface().(time.Time)
+		if t.IsZero() {
+			return nil, nil
+		}
+
+		if f.Time == TimeFormatRFC3339 {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+
+	if val.Type() == bytesType {
+		return val.Bytes(), nil
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		return val.String(), nil
+	case reflect.Bool:
+		return val.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), nil
+	case reflect.Map, reflect.Slice, reflect.Struct:
+		raw, err := json.Marshal(val.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return string(raw), nil
+	}
+
+	return nil, ErrorUnsupporttedDataType
+}
+
+// from sql value to field, the field will be zero value if null
+func (f *EntityColumn) fromValue(field reflect.Value, src interface{}) error {
+	if src == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Type() == timeType {
+		switch v := src.(type) {
+		case int64:
+			field.Set(reflect.ValueOf(time.Unix(0, v*int64(time.Millisecond))))
+			return nil
+		case string, []byte:
+			t, err := time.Parse(time.RFC3339Nano, toText(v))
+			if err != nil {
+				return err
+			}
+			field.Set(reflect.ValueOf(t))
+			return nil
+		case time.Time:
+			field.Set(reflect.ValueOf(v))
+			return nil
+		}
+
+		return ErrorUnsupporttedDataType
+	}
+
+	if field.Type() == bytesType {
+		switch v := src.(type) {
+		case []byte:
+			field.SetBytes(append([]byte(nil), v...))
+			return nil
+		case string:
+			field.SetBytes([]byte(v))
+			return nil
+		}
+
+		return ErrorUnsupporttedDataType
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		switch v := src.(type) {
+		case string, []byte:
+			field.SetString(toText(v))
+			return nil
+		}
+
+	case reflect.Bool:
+		switch v := src.(type) {
+		case bool:
+			field.SetBool(v)
+			return nil
+		case int64:
+			field.SetBool(v != 0)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v, ok := src.(int64); ok {
+			field.SetInt(v)
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v, ok := src.(int64); ok {
+			field.SetUint(uint64(v))
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch v := src.(type) {
+		case float64:
+			field.SetFloat(v)
+			return nil
+		case int64:
+			field.SetFloat(float64(v))
+			return nil
+		}
+
+	case reflect.Map, reflect.Slice, reflect.Struct:
+		switch v := src.(type) {
+		case string, []byte:
+			return json.Unmarshal([]byte(toText(v)), field.Addr().Interface())
+		}
+	}
+
+	return ErrorUnsupporttedDataType
+}
+
 func parseEntityColumn(field reflect.StructField) *EntityColumn {
 	val := field.Tag.Get(tag)
 
@@ -84,11 +286,15 @@ func parseEntityColumn(field reflect.StructField) *EntityColumn {
 
 		key := kv[0]
 		val := kv[1]
-		count++
 
 		fieldVal := reflect.ValueOf(entityField).Elem()
 		fieldOfEntityField := fieldVal.FieldByName(capitalFirstChar(key))
 
+		// unknown tag key
+		if !fieldOfEntityField.IsValid() {
+			continue
+		}
+
 		if fieldOfEntityField.Type().Kind() == reflect.String {
 			fieldOfEntityField.SetString(val)
 		}
@@ -97,6 +303,8 @@ func parseEntityColumn(field reflect.StructField) *EntityColumn {
 			b, _ := strconv.ParseBool(val)
 			fieldOfEntityField.SetBool(b)
 		}
+
+		count++
 	}
 
 	// no valid entity field
@@ -104,5 +312,16 @@ func parseEntityColumn(field reflect.StructField) *EntityColumn {
 		return nil
 	}
 
+	if util.IsEmptyString(entityField.Column) {
+		entityField.Column = flatCamelString(field.Name)
+	}
+
 	return entityField
 }
+
+func toText(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v.(string)
+}
@@ -53,3 +53,22 @@ func TestShouldGenColumnQueryForEntityField(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal("name TEXT", q)
 }
+
+func TestShouldParseColumnWithTypeAndIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	builder := initQueryBuilder(MockTypedEntity{})
+	assert.Equal(11, len(builder.columns))
+
+	query, err := builder.create()
+	assert.Nil(err)
+
+	expected := "CREATE TABLE IF NOT EXISTS mock_typed_entity (id TEXT NOT NULL PRIMARY KEY,name TEXT,count INTEGER," +
+		"size INTEGER,ratio REAL,enabled INTEGER,raw BLOB,labels TEXT,nested TEXT,created_at INTEGER,updated_at TEXT);"
+	assert.Equal(expected, query)
+
+	assert.Equal([]string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS uk_mock_typed_entity_name ON mock_typed_entity (name);",
+		"CREATE INDEX IF NOT EXISTS idx_mock_typed_entity_count ON mock_typed_entity (count);",
+	}, builder.indexes())
+}
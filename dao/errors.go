@@ -7,4 +7,10 @@ var (
 	ErrorDBTypeNotAvailable     = errors.New("db: db type not available")
 	ErrorPrimaryKeyCannotBeNull = errors.New("db: primary key cannot set to null")
 	ErrorUnsupporttedDataType   = errors.New("db: the data type not supported yet")
+	ErrorPrimaryKeyMissing      = errors.New("db: the entity does not have primary key")
+	ErrorEntityNotFound         = errors.New("db: the entity not found")
+	ErrorInvalidOutput          = errors.New("db: the output should be pointer of entity or entity slice")
+	ErrorUnknownColumn          = errors.New("db: unknown column")
+	ErrorMigrationOrder         = errors.New("db: the migration version should be increased")
+	ErrorSchemaTooNew           = errors.New("db: the schema version is newer than the agent, please upgrade the agent")
 )
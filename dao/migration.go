@@ -0,0 +1,167 @@
+package dao
+
+import (
+	"database/sql"
+	"time"
+
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	schemaVersionTable = "schema_version"
+)
+
+// Migration of schema, the version must be increased and never changed once released
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// Migrate create tables and add new columns of entities, then run migrations which are newer than schema version.
+// All changes are applied in one transaction, ErrorSchemaTooNew returned if the db is migrated by newer agent
+func (c *Client) Migrate(entities []interface{}, migrations []Migration) (out error) {
+	latest := 0
+	for _, m := range migrations {
+		if m.Version <= latest {
+			return ErrorMigrationOrder
+		}
+		latest = m.Version
+	}
+
+	tx, err := c.db.Begin()
+	if util.HasError(err) {
+		return err
+	}
+
+	defer func() {
+		if out != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	current, err := schemaVersion(tx)
+	if util.HasError(err) {
+		return err
+	}
+
+	if current > latest {
+		return ErrorSchemaTooNew
+	}
+
+	for _, entity := range entities {
+		if err = syncTable(tx, initQueryBuilder(entity)); util.HasError(err) {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err = m.Up(tx); util.HasError(err) {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO "+schemaVersionTable+" (version,description,applied_at) VALUES (?,?,?);",
+			m.Version, m.Description, time.Now().Unix())
+
+		if util.HasError(err) {
+			return err
+		}
+
+		util.LogInfo("[DB]: migrated to version %d: %s", m.Version, m.Description)
+	}
+
+	return tx.Commit()
+}
+
+// SchemaVersion the latest migration version applied, 0 if no migration
+func (c *Client) SchemaVersion() (int, error) {
+	tx, err := c.db.Begin()
+	if util.HasError(err) {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	return schemaVersion(tx)
+}
+
+func schemaVersion(tx *sql.Tx) (int, error) {
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + schemaVersionTable +
+		" (version INTEGER NOT NULL PRIMARY KEY,description TEXT,applied_at INTEGER);")
+
+	if util.HasError(err) {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = tx.QueryRow("SELECT MAX(version) FROM " + schemaVersionTable + ";").Scan(&version)
+	return int(version.Int64), err
+}
+
+// create table if not exists, and add nullable column for new fields
+func syncTable(tx *sql.Tx, builder *QueryBuilder) error {
+	sqlStmt, err := builder.create()
+	if util.HasError(err) {
+		return err
+	}
+
+	if _, err = tx.Exec(sqlStmt); util.HasError(err) {
+		return err
+	}
+
+	existing, err := tableColumns(tx, builder.table)
+	if util.HasError(err) {
+		return err
+	}
+
+	for _, c := range builder.columns {
+		if existing[c.Column] {
+			continue
+		}
+
+		q, err := c.toAddColumnQuery()
+		if util.HasError(err) {
+			return err
+		}
+
+		if _, err = tx.Exec("ALTER TABLE " + builder.table + " ADD COLUMN " + q + ";"); util.HasError(err) {
+			return err
+		}
+
+		util.LogInfo("[DB]: column '%s' added to '%s'", c.Column, builder.table)
+	}
+
+	for _, q := range builder.indexes() {
+		if _, err = tx.Exec(q); util.HasError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("PRAGMA table_info(" + table + ");")
+	if util.HasError(err) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultVal interface{}
+
+		if err = rows.Scan(&cid, &name, &dataType, &notNull, &defaultVal, &pk); util.HasError(err) {
+			return nil, err
+		}
+
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
@@ -0,0 +1,86 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldAddNewColumnAndRunMigrations(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "t")
+	defer os.RemoveAll(dir)
+
+	client, err := NewInstance(path.Join(dir, "test.db"))
+	assert.Nil(err)
+	defer client.Close()
+
+	// init: table created by old version without age column
+	_, err = client.db.Exec("CREATE TABLE mock_sub_entity (id TEXT NOT NULL PRIMARY KEY,name TEXT);")
+	assert.Nil(err)
+	_, err = client.db.Exec("INSERT INTO mock_sub_entity (id,name) VALUES ('1','yang');")
+	assert.Nil(err)
+
+	runs := 0
+	migrations := []Migration{
+		{Version: 1, Description: "init age", Up: func(tx *sql.Tx) error {
+			runs++
+			_, err := tx.Exec("UPDATE mock_sub_entity SET age=18;")
+			return err
+		}},
+	}
+
+	// when: migrate twice
+	assert.Nil(client.Migrate([]interface{}{MockSubEntity{}}, migrations))
+	assert.Nil(client.Migrate([]interface{}{MockSubEntity{}}, migrations))
+
+	// then: the column added and migration run once
+	assert.Equal(1, runs)
+
+	version, err := client.SchemaVersion()
+	assert.Nil(err)
+	assert.Equal(1, version)
+
+	entity := new(MockSubEntity)
+	assert.Nil(client.FindByID(entity, "1"))
+	assert.Equal(18, entity.Age)
+
+	// then: refuse to migrate by old agent
+	assert.Equal(ErrorSchemaTooNew, client.Migrate([]interface{}{MockSubEntity{}}, nil))
+}
+
+func TestShouldRollbackIfMigrationFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "t")
+	defer os.RemoveAll(dir)
+
+	client, err := NewInstance(path.Join(dir, "test.db"))
+	assert.Nil(err)
+	defer client.Close()
+
+	failure := errors.New("failure")
+	migrations := []Migration{
+		{Version: 1, Up: func(tx *sql.Tx) error { return nil }},
+		{Version: 2, Up: func(tx *sql.Tx) error { return failure }},
+	}
+
+	assert.Equal(failure, client.Migrate([]interface{}{MockSubEntity{}}, migrations))
+
+	version, err := client.SchemaVersion()
+	assert.Nil(err)
+	assert.Equal(0, version)
+
+	// then: the table creation been rolled back
+	assert.NotNil(client.FindByID(new(MockSubEntity), "1"))
+
+	// then: invalid order
+	migrations[1].Version = 1
+	assert.Equal(ErrorMigrationOrder, client.Migrate(nil, migrations))
+}
@@ -16,6 +16,9 @@ const (
 
 	// AgentIdle idle status
 	AgentIdle AgentStatus = "IDLE"
+
+	// AgentUnhealthy host checks failed, not accept job
+	AgentUnhealthy AgentStatus = "UNHEALTHY"
 )
 
 type (
@@ -26,6 +29,12 @@ type (
 		FreeMemory  uint64 `json:"freeMemory"`
 		TotalDisk   uint64 `json:"totalDisk"`
 		FreeDisk    uint64 `json:"freeDisk"`
+
+		// free disk of workspace is lower than threshold
+		DiskPressure bool `json:"diskPressure"`
+
+		// all host checks passed
+		Healthy bool `json:"healthy"`
 	}
 
 	// AgentConnect request data to get settings from server
@@ -34,6 +43,9 @@ type (
 		Port     int       `json:"port"`
 		Os       string    `json:"os"`
 		Resource *Resource `json:"resource"`
+
+		Capabilities *Capabilities `json:"capabilities"`
+		Tags         []string      `json:"tags"`
 	}
 
 	// Agent Class
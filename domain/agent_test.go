@@ -44,3 +44,16 @@ func TestAgentParesedFromJson(t *testing.T) {
 	assert.Equal(AgentStatus("OFFLINE"), agent.Status)
 	assert.Equal("job-id", agent.JobID)
 }
+
+func TestShouldGetTagsFromCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Capabilities{
+		Arch:       "amd64",
+		Docker:     &DockerInfo{Version: "18.09.2", StorageDriver: "overlay2"},
+		Toolchains: map[string]string{"go": "1.12.1", "java": "1.8.0"},
+		Labels:     []string{"iOS ", "go"},
+	}
+
+	assert.Equal([]string{"amd64", "docker", "go", "go-1.12", "ios", "java", "java-1.8", "linux"}, c.Tags("LINUX"))
+}
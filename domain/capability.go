@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// CapabilityTagDocker tag of agent with docker daemon
+	CapabilityTagDocker = "docker"
+
+	// CapabilityTagGpu tag of agent with gpu
+	CapabilityTagGpu = "gpu"
+)
+
+type (
+	// DockerInfo docker daemon on agent host
+	DockerInfo struct {
+		Version       string `json:"version"`
+		StorageDriver string `json:"storageDriver"`
+	}
+
+	// Capabilities detected from agent host, used by server to match jobs to agents
+	Capabilities struct {
+		Arch       string            `json:"arch"`
+		Kernel     string            `json:"kernel"`
+		Docker     *DockerInfo       `json:"docker"`
+		Toolchains map[string]string `json:"toolchains"` // name: version, ex: go: 1.12.1
+		Gpu        bool              `json:"gpu"`
+		Labels     []string          `json:"labels"` // custom labels from config
+	}
+)
+
+// Tags of capabilities which is the same as Agent.Tags, ex: [linux, amd64, docker, go, go-1.12, label]
+func (c *Capabilities) Tags(os string) []string {
+	set := make(map[string]bool)
+	add := func(tag string) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			set[tag] = true
+		}
+	}
+
+	add(os)
+	add(c.Arch)
+
+	if c.Docker != nil {
+		add(CapabilityTagDocker)
+	}
+
+	if c.Gpu {
+		add(CapabilityTagGpu)
+	}
+
+	for name, version := range c.Toolchains {
+		add(name)
+
+		// major.minor version tag, ex: java-1.8, go-1.12
+		parts := strings.SplitN(version, ".", 3)
+		if len(parts) >= 2 {
+			add(name + "-" + parts[0] + "." + parts[1])
+		}
+	}
+
+	for _, label := range c.Labels {
+		add(label)
+	}
+
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+	return tags
+}
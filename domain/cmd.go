@@ -26,6 +26,12 @@ const (
 
 	// CmdTypeSessionClose close session of interact mode
 	CmdTypeSessionClose CmdType = "SESSION_CLOSE"
+
+	// CmdTypeCheckout checkout git repo to job dir
+	CmdTypeCheckout CmdType = "CHECKOUT"
+
+	// CmdTypeBuild build docker image from dockerfile in job dir
+	CmdTypeBuild CmdType = "BUILD"
 )
 
 const (
@@ -58,6 +64,14 @@ const (
 	CmdExitCodeSuccess = 0
 )
 
+const (
+	// EnvFilterExact prefix of env filter to match exact env name, ex: =MY_VAR
+	EnvFilterExact = "="
+
+	// EnvFilterRegex wrapper of env filter to match env name by regex, ex: /^MY_.*_VAR$/
+	EnvFilterRegex = "/"
+)
+
 type (
 	DockerOption struct {
 		Image             string   `json:"image"`
@@ -66,6 +80,56 @@ type (
 		NetworkMode       string   `json:"networkMode"`
 		IsStopContainer   bool     `json:"isStopContainer"`
 		IsDeleteContainer bool     `json:"isDeleteContainer"`
+		WorkspaceMode     string   `json:"workspaceMode"` // volume or bind, the agent config is applied if empty
+		User              string   `json:"user"`          // uid:gid of step container, default is agent user in bind mode
+	}
+
+	// GitCredential credential for git checkout, value could be variable from secret, ex: ${MY_SSH_KEY}
+	GitCredential struct {
+		SshKey     string `json:"sshKey"` // content of ssh private key
+		Passphrase string `json:"passphrase"`
+		Username   string `json:"username"`
+		Token      string `json:"token"` // token or password for http(s) url
+
+		// skip ssh host key checking, the host key is verified by known_hosts by default
+		InsecureIgnoreHostKey bool `json:"insecureIgnoreHostKey"`
+	}
+
+	CheckoutOption struct {
+		Url        string         `json:"url"`
+		Ref        string         `json:"ref"`    // branch or tag, default is remote HEAD
+		Commit     string         `json:"commit"` // commit id has higher priority than ref
+		Depth      int            `json:"depth"`
+		Submodules bool           `json:"submodules"`
+		Lfs        bool           `json:"lfs"`
+		Dir        string         `json:"dir"` // sub dir of job dir
+		Credential *GitCredential `json:"credential"`
+	}
+
+	// RegistryAuth credential of docker registry, value could be variable from secret
+	RegistryAuth struct {
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		ServerAddress string `json:"serverAddress"`
+	}
+
+	BuildOption struct {
+		Context    string            `json:"context"`    // sub dir of job dir, default is job dir
+		Dockerfile string            `json:"dockerfile"` // relative to context, default is Dockerfile
+		Args       map[string]string `json:"args"`
+		Target     string            `json:"target"` // stage of multi-stage build, the last stage if empty
+		Tags       []string          `json:"tags"`
+		CacheFrom  []string          `json:"cacheFrom"`
+		NoCache    bool              `json:"noCache"`
+		Pull       bool              `json:"pull"` // always pull base image
+		Push       bool              `json:"push"` // push tags after build
+		Auth       *RegistryAuth     `json:"auth"`
+	}
+
+	// PtyOption run cmd in pseudo terminal, the default size is applied if not set
+	PtyOption struct {
+		Cols uint16 `json:"cols"`
+		Rows uint16 `json:"rows"`
 	}
 
 	Cmd struct {
@@ -87,7 +151,19 @@ type (
 		Scripts    []string  `json:"scripts"`
 		Timeout    int       `json:"timeout"`
 		Inputs     Variables `json:"inputs"`
-		EnvFilters []string  `json:"envFilters"`
+		Meta       VarMetas  `json:"meta"`       // metadata of inputs
+		EnvFilters []string  `json:"envFilters"` // prefix, '=NAME' for exact name, glob or '/regex/'
+
+		Checkout *CheckoutOption `json:"checkout"`
+		Build    *BuildOption    `json:"build"`
+		Pty      *PtyOption      `json:"pty"`
+
+		// remove everything in job dir before cmd
+		CleanWorkspace bool `json:"cleanWorkspace"`
+
+		// max log size in MB which overrides agent config, the cmd is killed on exceeded if kill enabled
+		MaxLogSize       int64 `json:"maxLogSize"`
+		KillOnMaxLogSize bool  `json:"killOnMaxLogSize"`
 	}
 
 	ExecutedCmd struct {
@@ -134,6 +210,18 @@ func (in *CmdIn) HasEnvFilters() bool {
 	return len(in.EnvFilters) != 0
 }
 
+func (in *CmdIn) HasCheckoutOption() bool {
+	return in.Checkout != nil
+}
+
+func (in *CmdIn) HasBuildOption() bool {
+	return in.Build != nil
+}
+
+func (in *CmdIn) HasPtyOption() bool {
+	return in.Pty != nil
+}
+
 func (in *CmdIn) VarsToStringArray() []string {
 	if !NilOrEmpty(in.Inputs) {
 		return in.Inputs.ToStringArray()
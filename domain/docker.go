@@ -6,6 +6,20 @@ import (
 	"strings"
 )
 
+const (
+	// DockerLabelAgent label of step container with agent id, used to find containers created by agent
+	DockerLabelAgent = "flow.ci.agent"
+
+	// DockerAgentVolumePrefix agent volume is named by prefix and agent id, which is the workspace in volume mode
+	DockerAgentVolumePrefix = "agent-"
+
+	// WorkspaceModeVolume the agent volume is mounted to /ws in step container
+	WorkspaceModeVolume = "volume"
+
+	// WorkspaceModeBind the host job dir is bind-mounted to the same path in step container
+	WorkspaceModeBind = "bind"
+)
+
 type (
 	// DockerVolume volume will mount to step docker
 	DockerVolume struct {
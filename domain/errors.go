@@ -0,0 +1,8 @@
+package domain
+
+import "errors"
+
+var (
+	ErrorLogVersionMissing     = errors.New("agent: the version of log message is missing")
+	ErrorLogVersionUnsupported = errors.New("agent: unsupported version of log message")
+)
@@ -0,0 +1,29 @@
+package domain
+
+import "time"
+
+// HealthStatus string of host check status
+type HealthStatus string
+
+const (
+	HealthPassed HealthStatus = "PASSED"
+
+	HealthFailed HealthStatus = "FAILED"
+
+	HealthSkipped HealthStatus = "SKIPPED"
+)
+
+type (
+	// HealthCheck result of host check
+	HealthCheck struct {
+		Name      string       `json:"name"`
+		Status    HealthStatus `json:"status"`
+		Message   string       `json:"message"`
+		CheckedAt time.Time    `json:"checkedAt"`
+	}
+)
+
+// IsFailed check is failed, skipped check is not failed
+func (c *HealthCheck) IsFailed() bool {
+	return c.Status == HealthFailed
+}
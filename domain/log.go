@@ -1,27 +1,54 @@
 package domain
 
+//go:generate protoc --go_out=. log.proto
+
 import (
-	"bytes"
+	"time"
+
+	"github.com/golang/protobuf/proto"
 )
 
 const (
-	logSeparator = '\003'
+	// LogMessageVersion version of LogMessage schema in log.proto
+	LogMessageVersion = 1
+
+	// policy when log buffer is full
+	LogOverflowSpill = "spill"
+	LogOverflowDrop  = "drop"
 )
 
+// LogItem log content from executor
 type LogItem struct {
 	CmdId   string
+	Stream  LogStream
 	Content []byte
 }
 
-// format: {length of id}003{cmd id}003{content}
-func (log *LogItem) Write(buffer *bytes.Buffer) []byte {
-	i := len(log.CmdId)
-	buffer.WriteByte(uint8(i))
-	buffer.WriteByte(logSeparator)
+// EncodeLogMessage protobuf bytes of log message with current version
+func EncodeLogMessage(msg *LogMessage) ([]byte, error) {
+	msg.Version = LogMessageVersion
+	return proto.Marshal(msg)
+}
+
+// DecodeLogMessage log message from protobuf bytes, the message from newer version is not supported
+func DecodeLogMessage(data []byte) (*LogMessage, error) {
+	msg := &LogMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	if msg.Version == 0 {
+		return nil, ErrorLogVersionMissing
+	}
 
-	buffer.WriteString(log.CmdId)
-	buffer.WriteByte(logSeparator)
+	if msg.Version > LogMessageVersion {
+		return nil, ErrorLogVersionUnsupported
+	}
+
+	return msg, nil
+}
 
-	buffer.Write(log.Content)
-	return buffer.Bytes()
+// LogTimestamp unix time in ms of log message
+func LogTimestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
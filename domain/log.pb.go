@@ -0,0 +1,166 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: log.proto
+
+package domain
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// LogStream source of log content
+type LogStream int32
+
+const (
+	LogStream_STDOUT LogStream = 0
+	LogStream_STDERR LogStream = 1
+	LogStream_SYSTEM LogStream = 2
+)
+
+var LogStream_name = map[int32]string{
+	0: "STDOUT",
+	1: "STDERR",
+	2: "SYSTEM",
+}
+
+var LogStream_value = map[string]int32{
+	"STDOUT": 0,
+	"STDERR": 1,
+	"SYSTEM": 2,
+}
+
+func (x LogStream) String() string {
+	return proto.EnumName(LogStream_name, int32(x))
+}
+
+func (LogStream) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a153da538f858886, []int{0}
+}
+
+// LogMessage log content of cmd pushed to server, the version should be increased if any breaking change
+type LogMessage struct {
+	Version              uint32    `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	CmdId                string    `protobuf:"bytes,2,opt,name=cmd_id,json=cmdId,proto3" json:"cmd_id,omitempty"`
+	JobId                string    `protobuf:"bytes,3,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	Seq                  uint64    `protobuf:"varint,4,opt,name=seq,proto3" json:"seq,omitempty"`
+	Stream               LogStream `protobuf:"varint,5,opt,name=stream,proto3,enum=domain.LogStream" json:"stream,omitempty"`
+	Timestamp            int64     `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Content              []byte    `protobuf:"bytes,7,opt,name=content,proto3" json:"content,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *LogMessage) Reset()         { *m = LogMessage{} }
+func (m *LogMessage) String() string { return proto.CompactTextString(m) }
+func (*LogMessage) ProtoMessage()    {}
+func (*LogMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a153da538f858886, []int{0}
+}
+
+func (m *LogMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LogMessage.Unmarshal(m, b)
+}
+func (m *LogMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LogMessage.Marshal(b, m, deterministic)
+}
+func (m *LogMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LogMessage.Merge(m, src)
+}
+func (m *LogMessage) XXX_Size() int {
+	return xxx_messageInfo_LogMessage.Size(m)
+}
+func (m *LogMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_LogMessage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LogMessage proto.InternalMessageInfo
+
+func (m *LogMessage) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *LogMessage) GetCmdId() string {
+	if m != nil {
+		return m.CmdId
+	}
+	return ""
+}
+
+func (m *LogMessage) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+func (m *LogMessage) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *LogMessage) GetStream() LogStream {
+	if m != nil {
+		return m.Stream
+	}
+	return LogStream_STDOUT
+}
+
+func (m *LogMessage) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *LogMessage) GetContent() []byte {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("domain.LogStream", LogStream_name, LogStream_value)
+	proto.RegisterType((*LogMessage)(nil), "domain.LogMessage")
+}
+
+func init() {
+	proto.RegisterFile("log.proto", fileDescriptor_a153da538f858886)
+}
+
+var fileDescriptor_a153da538f858886 = []byte{
+	// 237 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0x90, 0x41, 0x4b, 0xc3, 0x30,
+	0x18, 0x86, 0xfd, 0xd6, 0x2d, 0xb3, 0x1f, 0x2a, 0x35, 0x20, 0xe4, 0xe0, 0x21, 0x78, 0x8a, 0x1e,
+	0x2a, 0xe8, 0x3f, 0x10, 0x77, 0x18, 0x6c, 0x08, 0x69, 0x3d, 0xe8, 0x45, 0xda, 0x26, 0x94, 0x0e,
+	0xd3, 0x6f, 0x36, 0xc1, 0xff, 0xe8, 0xbf, 0x92, 0x74, 0x9d, 0xde, 0x9e, 0xf7, 0x49, 0xf8, 0x78,
+	0x79, 0x31, 0xfd, 0xa4, 0x36, 0xdf, 0x0f, 0x14, 0x88, 0x33, 0x43, 0xae, 0xea, 0xfa, 0x9b, 0x1f,
+	0x40, 0xdc, 0x50, 0xbb, 0xb5, 0xde, 0x57, 0xad, 0xe5, 0x02, 0x97, 0xdf, 0x76, 0xf0, 0x1d, 0xf5,
+	0x02, 0x24, 0xa8, 0x73, 0x7d, 0x8c, 0xfc, 0x0a, 0x59, 0xe3, 0xcc, 0x47, 0x67, 0xc4, 0x4c, 0x82,
+	0x4a, 0xf5, 0xa2, 0x71, 0x66, 0x6d, 0xa2, 0xde, 0x51, 0x1d, 0x75, 0x72, 0xd0, 0x3b, 0xaa, 0xd7,
+	0x86, 0x67, 0x98, 0x78, 0xfb, 0x25, 0xe6, 0x12, 0xd4, 0x5c, 0x47, 0xe4, 0xb7, 0xc8, 0x7c, 0x18,
+	0x6c, 0xe5, 0xc4, 0x42, 0x82, 0xba, 0x78, 0xb8, 0xcc, 0x0f, 0x0d, 0xf2, 0x0d, 0xb5, 0xc5, 0xf8,
+	0xa0, 0xa7, 0x0f, 0xfc, 0x1a, 0xd3, 0xd0, 0x39, 0xeb, 0x43, 0xe5, 0xf6, 0x82, 0x49, 0x50, 0x89,
+	0xfe, 0x17, 0xb1, 0x62, 0x43, 0x7d, 0xb0, 0x7d, 0x10, 0x4b, 0x09, 0xea, 0x4c, 0x1f, 0xe3, 0xdd,
+	0x3d, 0xa6, 0x7f, 0xc7, 0x38, 0x22, 0x2b, 0xca, 0xe7, 0x97, 0xd7, 0x32, 0x3b, 0x99, 0x78, 0xa5,
+	0x75, 0x06, 0x23, 0xbf, 0x15, 0xe5, 0x6a, 0x9b, 0xcd, 0x9e, 0x4e, 0xdf, 0xa7, 0x19, 0x6a, 0x36,
+	0xae, 0xf2, 0xf8, 0x3b, 0x00, 0x96, 0x52, 0x07, 0xd7, 0x22, 0x01, 0x00, 0x00,
+}
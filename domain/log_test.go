@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldEncodeAndDecodeLogMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	// the id longer than 255 bytes was broken in previous format
+	cmdId := strings.Repeat("c", 300)
+
+	data, err := EncodeLogMessage(&LogMessage{
+		CmdId:     cmdId,
+		JobId:     "job-1",
+		Seq:       10,
+		Stream:    LogStream_STDERR,
+		Timestamp: 1590000000000,
+		Content:   []byte("hello\n"),
+	})
+	assert.NoError(err)
+
+	msg, err := DecodeLogMessage(data)
+	assert.NoError(err)
+	assert.Equal(uint32(LogMessageVersion), msg.Version)
+	assert.Equal(cmdId, msg.CmdId)
+	assert.Equal("job-1", msg.JobId)
+	assert.Equal(uint64(10), msg.Seq)
+	assert.Equal(LogStream_STDERR, msg.Stream)
+	assert.Equal(int64(1590000000000), msg.Timestamp)
+	assert.Equal("hello\n", string(msg.Content))
+}
+
+func TestShouldNotDecodeLogMessageWithoutSupportedVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	data, _ := proto.Marshal(&LogMessage{CmdId: "1-1-1"})
+	_, err := DecodeLogMessage(data)
+	assert.Equal(ErrorLogVersionMissing, err)
+
+	data, _ = proto.Marshal(&LogMessage{Version: LogMessageVersion + 1, CmdId: "1-1-1"})
+	_, err = DecodeLogMessage(data)
+	assert.Equal(ErrorLogVersionUnsupported, err)
+}
+
+func TestShouldKeepLogMessageWireFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	// field numbers and types must not be changed for compatibility
+	data, err := EncodeLogMessage(&LogMessage{CmdId: "a", Seq: 1, Stream: LogStream_SYSTEM, Content: []byte("x")})
+	assert.NoError(err)
+	assert.Equal([]byte{0x08, 0x01, 0x12, 0x01, 'a', 0x20, 0x01, 0x28, 0x02, 0x3a, 0x01, 'x'}, data)
+}
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+const (
+	OutboxKindResult = "RESULT"
+	OutboxKindLog    = "LOG"
+	OutboxKindUpload = "UPLOAD"
+)
+
+type (
+	// OutboxMessage message buffered in agent db while server or broker unavailable
+	OutboxMessage struct {
+		Seq         int64     `db:"column=seq,pk=true,nullable=false"`
+		Kind        string    `db:"column=kind,index=true,nullable=false"`
+		Exchange    string    `db:"column=exchange"`
+		RoutingKey  string    `db:"column=routing_key"`
+		ContentType string    `db:"column=content_type"`
+		Encoding    string    `db:"column=encoding"`
+		Body        []byte    `db:"column=body"`
+		FilePath    string    `db:"column=file_path"`
+		Size        int64     `db:"column=size"`
+		CreatedAt   time.Time `db:"column=created_at"`
+	}
+
+	// OutboxStats backlog of outbox
+	OutboxStats struct {
+		Count int64 `json:"count"`
+		Size  int64 `json:"size"`
+	}
+)
@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// PluginManifestFile manifest file name in plugin root dir
+	PluginManifestFile = "plugin.yml"
+)
+
+type (
+	// PluginInput input variable declared by plugin
+	PluginInput struct {
+		Name     string `yaml:"name"`
+		Required bool   `yaml:"required"`
+		Default  string `yaml:"default"`
+	}
+
+	// PluginDocker docker image to run the plugin
+	PluginDocker struct {
+		Image string `yaml:"image"`
+	}
+
+	// Plugin manifest from plugin.yml
+	Plugin struct {
+		Name    string         `yaml:"name"`
+		Version string         `yaml:"version"`
+		Inputs  []*PluginInput `yaml:"inputs"`
+		Exports []string       `yaml:"exports"`
+		Script  string         `yaml:"script"` // entrypoint script related to plugin dir
+		Docker  *PluginDocker  `yaml:"docker"`
+	}
+)
+
+// NewPluginFromYaml parse plugin manifest
+func NewPluginFromYaml(raw []byte) (*Plugin, error) {
+	var plugin Plugin
+	if err := yaml.Unmarshal(raw, &plugin); err != nil {
+		return nil, fmt.Errorf("agent: invalid plugin manifest: %v", err)
+	}
+
+	for _, input := range plugin.Inputs {
+		if input == nil || input.Name == "" {
+			return nil, fmt.Errorf("agent: invalid plugin manifest: input name is missing")
+		}
+	}
+
+	// script should be inside plugin dir since it's sourced in cmd
+	if plugin.HasScript() {
+		script := path.Clean(plugin.Script)
+		if path.IsAbs(script) || script == ".." || strings.HasPrefix(script, "../") {
+			return nil, fmt.Errorf("agent: invalid plugin manifest: script '%s' is outside plugin dir", plugin.Script)
+		}
+		plugin.Script = script
+	}
+
+	return &plugin, nil
+}
+
+func (p *Plugin) HasScript() bool {
+	return p.Script != ""
+}
+
+func (p *Plugin) HasDocker() bool {
+	return p.Docker != nil && p.Docker.Image != ""
+}
+
+// ApplyInputs set default value of inputs, and return error if required inputs are missing
+func (p *Plugin) ApplyInputs(inputs Variables) error {
+	var missing []string
+
+	for _, input := range p.Inputs {
+		if val, ok := inputs[input.Name]; ok && val != "" {
+			continue
+		}
+
+		if input.Default != "" {
+			inputs[input.Name] = input.Default
+			continue
+		}
+
+		if input.Required {
+			missing = append(missing, input.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("agent: plugin '%s' missing required inputs: %s", p.Name, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	pluginYaml = []byte(`
+name: maven-test
+version: 1.0
+inputs:
+  - name: MVN_GOAL
+    default: test
+  - name: MVN_PROFILE
+    required: true
+exports:
+  - MVN_REPORT
+script: run.sh
+docker:
+  image: maven:3-jdk-8
+`)
+)
+
+func TestShouldParsePluginManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	plugin, err := NewPluginFromYaml(pluginYaml)
+	assert.NoError(err)
+
+	assert.Equal("maven-test", plugin.Name)
+	assert.Equal(2, len(plugin.Inputs))
+	assert.True(plugin.Inputs[1].Required)
+	assert.Equal([]string{"MVN_REPORT"}, plugin.Exports)
+	assert.True(plugin.HasScript())
+	assert.True(plugin.HasDocker())
+	assert.Equal("maven:3-jdk-8", plugin.Docker.Image)
+}
+
+func TestShouldRejectPluginScriptOutsidePluginDir(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewPluginFromYaml([]byte("name: demo\nscript: ../../evil.sh\n"))
+	assert.Error(err)
+
+	_, err = NewPluginFromYaml([]byte("name: demo\nscript: /tmp/evil.sh\n"))
+	assert.Error(err)
+
+	plugin, err := NewPluginFromYaml([]byte("name: demo\nscript: ./bin/../run.sh\n"))
+	assert.NoError(err)
+	assert.Equal("run.sh", plugin.Script)
+}
+
+func TestShouldApplyPluginInputs(t *testing.T) {
+	assert := assert.New(t)
+
+	plugin, _ := NewPluginFromYaml(pluginYaml)
+
+	inputs := Variables{}
+	err := plugin.ApplyInputs(inputs)
+	assert.Error(err)
+	assert.Contains(err.Error(), "MVN_PROFILE")
+
+	inputs = Variables{"MVN_PROFILE": "dev"}
+	assert.NoError(plugin.ApplyInputs(inputs))
+	assert.Equal("test", inputs["MVN_GOAL"])
+	assert.Equal("dev", inputs["MVN_PROFILE"])
+}
@@ -15,6 +15,17 @@ type (
 		Response
 		Data *Settings
 	}
+
+	PluginChecksumResponse struct {
+		Response
+		Data string
+	}
+
+	// LogUploadResponse bytes of log file received by server
+	LogUploadResponse struct {
+		Response
+		Data int64
+	}
 )
 
 // IsOk check response code is equal to 200
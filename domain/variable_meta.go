@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type VarType string
+
+const (
+	VarTypeString VarType = "string"
+	VarTypeNumber VarType = "number"
+	VarTypeBool   VarType = "bool"
+	VarTypeSecret VarType = "secret"
+	VarTypeFile   VarType = "file"
+	VarTypeList   VarType = "list"
+)
+
+type (
+	// VarMeta metadata of variable
+	VarMeta struct {
+		Type     VarType `json:"type"`
+		Exported *bool   `json:"exported"` // export to process env, default is true
+	}
+
+	// VarMetas metadata of variables by name, the string type is applied if not defined
+	VarMetas map[string]*VarMeta
+)
+
+var (
+	defaultVarMeta = &VarMeta{Type: VarTypeString}
+)
+
+// Get metadata of variable, return default string type if not defined
+func (m VarMetas) Get(name string) *VarMeta {
+	if meta, ok := m[name]; ok && meta != nil {
+		return meta
+	}
+	return defaultVarMeta
+}
+
+// Names of variables with the type
+func (m VarMetas) Names(t VarType) []string {
+	var names []string
+	for name, meta := range m {
+		if meta != nil && meta.Type == t {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Normalize validate and format typed variable value, ex: bool to true/false, list to line separated
+func (m VarMetas) Normalize(vars Variables) error {
+	for name, meta := range m {
+		val, ok := vars[name]
+		if !ok || meta == nil {
+			continue
+		}
+
+		normalized, err := meta.normalize(val)
+		if err != nil {
+			return fmt.Errorf("agent: invalid %s variable '%s': %v", meta.Type, name, err)
+		}
+
+		vars[name] = normalized
+	}
+
+	return nil
+}
+
+func (meta *VarMeta) IsExported() bool {
+	return meta.Exported == nil || *meta.Exported
+}
+
+func (meta *VarMeta) IsSecret() bool {
+	return meta.Type == VarTypeSecret
+}
+
+func (meta *VarMeta) IsFile() bool {
+	return meta.Type == VarTypeFile
+}
+
+func (meta *VarMeta) normalize(val string) (string, error) {
+	switch meta.Type {
+	case VarTypeNumber:
+		if _, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err != nil {
+			return val, err
+		}
+		return strings.TrimSpace(val), nil
+
+	case VarTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return val, err
+		}
+		return strconv.FormatBool(b), nil
+
+	case VarTypeList:
+		// json array to line separated string, otherwise keep as it is
+		if !strings.HasPrefix(strings.TrimSpace(val), "[") {
+			return val, nil
+		}
+
+		var items []string
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return val, err
+		}
+		return strings.Join(items, "\n"), nil
+
+	default:
+		return val, nil
+	}
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"fmt"
 	"github/flowci/flow-agent-x/util"
+	"os"
 )
 
 const (
@@ -15,6 +16,67 @@ const (
 	VarAgentPluginDir = "FLOWCI_AGENT_PLUGIN_DIR"
 	VarAgentLogDir    = "FLOWCI_AGENT_LOG_DIR"
 	VarAgentVolumes    = "FLOWCI_AGENT_VOLUMES"
+	VarAgentProxy     = "FLOWCI_AGENT_PROXY"
+
+	VarAgentSettingsInterval = "FLOWCI_AGENT_SETTINGS_INTERVAL"
+	VarAgentVerifyPlugin     = "FLOWCI_AGENT_VERIFY_PLUGIN"
+
+	VarAgentLabels        = "FLOWCI_AGENT_LABELS"
+	VarAgentWorkspaceMode = "FLOWCI_AGENT_WORKSPACE_MODE"
+	VarAgentDurableQueue  = "FLOWCI_AGENT_DURABLE_QUEUE"
+
+	VarAgentHealthInterval = "FLOWCI_AGENT_HEALTH_INTERVAL"
+	VarAgentMinFreeDisk    = "FLOWCI_AGENT_MIN_FREE_DISK"
+	VarAgentCheckDocker    = "FLOWCI_AGENT_CHECK_DOCKER"
+
+	VarAgentGCInterval       = "FLOWCI_AGENT_GC_INTERVAL"
+	VarAgentGCMaxAge         = "FLOWCI_AGENT_GC_MAX_AGE"
+	VarAgentWorkspaceMaxAge  = "FLOWCI_AGENT_WORKSPACE_MAX_AGE"
+	VarAgentWorkspaceMaxSize = "FLOWCI_AGENT_WORKSPACE_MAX_SIZE"
+	VarAgentWorkspaceKeep    = "FLOWCI_AGENT_WORKSPACE_KEEP"
+
+	VarAgentOutboxMaxSize = "FLOWCI_AGENT_OUTBOX_MAX_SIZE"
+
+	VarAgentLogBatchSize     = "FLOWCI_AGENT_LOG_BATCH_SIZE"
+	VarAgentLogBatchInterval = "FLOWCI_AGENT_LOG_BATCH_INTERVAL"
+	VarAgentLogCompress      = "FLOWCI_AGENT_LOG_COMPRESS"
+	VarAgentLogBufferSize    = "FLOWCI_AGENT_LOG_BUFFER_SIZE"
+	VarAgentLogOverflow      = "FLOWCI_AGENT_LOG_OVERFLOW"
+	VarAgentLogStripAnsi     = "FLOWCI_AGENT_LOG_STRIP_ANSI"
+
+	VarAgentMaxLogSize       = "FLOWCI_AGENT_MAX_LOG_SIZE"
+	VarAgentKillOnMaxLogSize = "FLOWCI_AGENT_KILL_ON_MAX_LOG_SIZE"
+
+	VarAgentPreCmdHook    = "FLOWCI_AGENT_PRE_CMD"
+	VarAgentPostCmdHook   = "FLOWCI_AGENT_POST_CMD"
+	VarAgentOnFailureHook = "FLOWCI_AGENT_ON_FAILURE"
+
+	VarCmdId       = "FLOWCI_CMD_ID"
+	VarCmdFlowId   = "FLOWCI_CMD_FLOW_ID"
+	VarCmdJobId    = "FLOWCI_CMD_JOB_ID"
+	VarCmdNodePath = "FLOWCI_CMD_NODE_PATH"
+	VarCmdBuildNum = "FLOWCI_CMD_BUILD_NUMBER"
+	VarCmdExitCode = "FLOWCI_CMD_EXIT_CODE"
+
+	VarPluginPath = "FLOWCI_PLUGIN_PATH"
+	VarOutputFile = "FLOWCI_OUTPUT"
+
+	VarGitUrl           = "FLOWCI_GIT_URL"
+	VarGitBranch        = "FLOWCI_GIT_BRANCH"
+	VarGitCommitId      = "FLOWCI_GIT_COMMIT_ID"
+	VarGitCommitMessage = "FLOWCI_GIT_COMMIT_MESSAGE"
+	VarGitCommitAuthor  = "FLOWCI_GIT_COMMIT_AUTHOR"
+	VarGitCommitTime    = "FLOWCI_GIT_COMMIT_TIME"
+
+	VarBuildImageId      = "FLOWCI_BUILD_IMAGE_ID"
+	VarBuildImageTags    = "FLOWCI_BUILD_IMAGE_TAGS"
+	VarBuildImageDigests = "FLOWCI_BUILD_IMAGE_DIGESTS"
+)
+
+const (
+	// the type key of variables which is required by server to parse the vars, never applied to env
+	varsTypeKey = "_TYPE_"
+	varsTypeVal = "_string_"
 )
 
 // Variables applied for environment variable as key, value
@@ -22,7 +84,7 @@ type Variables map[string]string
 
 func NewVariables() Variables {
 	return Variables{
-		"_TYPE_": "_string_",
+		varsTypeKey: varsTypeVal,
 	}
 }
 
@@ -51,32 +113,90 @@ func (v Variables) Copy() Variables {
 	return copied
 }
 
+// Lookup get value of variable and whether it's defined
+func (v Variables) Lookup(name string) (string, bool) {
+	val, ok := v[name]
+	return val, ok
+}
+
 func (v Variables) Size() int {
 	return len(v)
 }
 
-// Resolve to gain actual value from env variables
-func (v Variables) Resolve() {
-	// resolve from system env vars
-	for key, val := range v {
-		val = util.ParseString(val)
+// Resolve to gain actual value of ${...} from current variables and system env variables, the undefined is empty,
+// the secret values are kept as it is, return error if variable has cyclic reference or required variable is missing
+func (v Variables) Resolve(metas VarMetas) error {
+	const (
+		resolving = 1
+		resolved  = 2
+	)
+
+	state := make(map[string]int, v.Size())
+	var failure error
+
+	var resolve func(key string) string
+	resolve = func(key string) string {
+		switch state[key] {
+		case resolved:
+			return v[key]
+		case resolving:
+			if failure == nil {
+				failure = fmt.Errorf("agent: cyclic reference of variable '%s'", key)
+			}
+			return v[key]
+		}
+
+		if metas.Get(key).IsSecret() {
+			state[key] = resolved
+			return v[key]
+		}
+
+		state[key] = resolving
+
+		val, err := util.ExpandBraced(v[key], func(name string) (string, bool) {
+			// self reference from system env, ex: PATH=${PATH}:/usr/local/bin
+			if name == key {
+				return os.LookupEnv(name)
+			}
+
+			if _, ok := v[name]; ok {
+				return resolve(name), true
+			}
+
+			return os.LookupEnv(name)
+		})
+
+		if err != nil && failure == nil {
+			failure = err
+		}
+
 		v[key] = val
+		state[key] = resolved
+		return val
 	}
 
-	// resolve from current env vars
-	for key, val := range v {
-		val = util.ParseStringWithSource(val, v)
-		v[key] = val
+	for key := range v {
+		resolve(key)
 	}
+
+	return failure
 }
 
 // ToStringArray convert variables map to key=value string array
 func (v Variables) ToStringArray() []string {
-	array := make([]string, v.Size())
-	index := 0
+	return v.ToEnvArray(nil)
+}
+
+// ToEnvArray convert variables to key=value string array for process env,
+// the type key and the variables not exported from metadata are excluded
+func (v Variables) ToEnvArray(metas VarMetas) []string {
+	array := make([]string, 0, v.Size())
 	for key, val := range v {
-		array[index] = fmt.Sprintf("%s=%s", key, val)
-		index++
+		if key == varsTypeKey || !metas.Get(key).IsExported() {
+			continue
+		}
+
+		array = append(array, fmt.Sprintf("%s=%s", key, val))
 	}
 
 	return array
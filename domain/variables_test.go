@@ -32,12 +32,13 @@ func TestShouldDeepCopy(t *testing.T) {
 
 func TestShouldToStringArrayWithEnvVariables(t *testing.T) {
 	assert := assert.New(t)
+	setTestUser()
 
 	variables := Variables{
 		"SAY_HELLO": "${USER} hello",
 	}
 
-	variables.Resolve()
+	variables.Resolve(nil)
 	array := variables.ToStringArray()
 	assert.NotNil(array)
 	assert.Equal(fmt.Sprintf("SAY_HELLO=%s hello", os.Getenv("USER")), array[0])
@@ -45,19 +46,20 @@ func TestShouldToStringArrayWithEnvVariables(t *testing.T) {
 
 func TestShouldToStringArrayWithNestedEnvVariables(t *testing.T) {
 	assert := assert.New(t)
+	setTestUser()
 
 	variables := Variables{
 		"NESTED_HELLO": "${SAY_HELLO} hello",
-		"SAY_HELLO": "${USER} hello",
+		"SAY_HELLO":    "${USER} hello",
 	}
 
-	variables.Resolve()
+	variables.Resolve(nil)
 	array := variables.ToStringArray()
 	assert.NotNil(array)
 	assert.Equal(2, len(array))
 
-	assert.Equal(fmt.Sprintf("NESTED_HELLO=%s hello hello", os.Getenv("USER")), array[0])
-	assert.Equal(fmt.Sprintf("SAY_HELLO=%s hello", os.Getenv("USER")), array[1])
+	assert.Contains(array, fmt.Sprintf("NESTED_HELLO=%s hello hello", os.Getenv("USER")))
+	assert.Contains(array, fmt.Sprintf("SAY_HELLO=%s hello", os.Getenv("USER")))
 }
 
 func TestShouldConnectVariables(t *testing.T) {
@@ -65,7 +67,7 @@ func TestShouldConnectVariables(t *testing.T) {
 
 	varA := Variables{
 		"NESTED_HELLO": "${SAY_HELLO_A} hello",
-		"SAY_HELLO_A": "hello A",
+		"SAY_HELLO_A":  "hello A",
 	}
 
 	varB := Variables{
@@ -76,8 +78,93 @@ func TestShouldConnectVariables(t *testing.T) {
 	assert.NotNil(vars)
 	assert.Equal(3, vars.Size())
 
-	vars.Resolve()
+	vars.Resolve(nil)
 	assert.Equal("hello A hello", vars["NESTED_HELLO"])
 	assert.Equal("hello A", vars["SAY_HELLO_A"])
 	assert.Equal("hello B", vars["SAY_HELLO_B"])
 }
+
+func TestShouldResolveWithDefaultAndSelfReference(t *testing.T) {
+	assert := assert.New(t)
+
+	variables := Variables{
+		"IMAGE":   "ubuntu:${TAG:-18.04}",
+		"TAG":     "",
+		"PATH":    "${PATH}:/opt/bin",
+		"MESSAGE": "image is ${IMAGE}",
+		"MISSING": "${UNDEFINED_VAR}/bin",
+	}
+
+	assert.NoError(variables.Resolve(nil))
+	assert.Equal("ubuntu:18.04", variables["IMAGE"])
+	assert.Equal(os.Getenv("PATH")+":/opt/bin", variables["PATH"])
+	assert.Equal("image is ubuntu:18.04", variables["MESSAGE"])
+	assert.Equal("/bin", variables["MISSING"])
+}
+
+func TestShouldNotResolveSecretAndUnbracedValue(t *testing.T) {
+	assert := assert.New(t)
+
+	variables := Variables{
+		"TOKEN":    "pa$$word${USER}",
+		"PASSWORD": "pa$$word $TOKEN",
+		"MESSAGE":  "token is ${TOKEN}",
+	}
+
+	assert.NoError(variables.Resolve(VarMetas{"TOKEN": {Type: VarTypeSecret}}))
+	assert.Equal("pa$$word${USER}", variables["TOKEN"])
+	assert.Equal("pa$$word $TOKEN", variables["PASSWORD"])
+	assert.Equal("token is pa$$word${USER}", variables["MESSAGE"])
+}
+
+func TestShouldReportCyclicReference(t *testing.T) {
+	assert := assert.New(t)
+
+	variables := Variables{
+		"A": "${B}",
+		"B": "${C}",
+		"C": "${A}",
+	}
+
+	err := variables.Resolve(nil)
+	assert.Error(err)
+	assert.Contains(err.Error(), "cyclic reference")
+}
+
+func TestShouldExcludeTypeKeyAndNotExportedFromEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	exported := false
+	variables := NewVariables()
+	variables["HELLO"] = "world"
+	variables["HIDDEN"] = "value"
+
+	array := variables.ToEnvArray(VarMetas{"HIDDEN": {Type: VarTypeString, Exported: &exported}})
+	assert.Equal([]string{"HELLO=world"}, array)
+}
+
+func TestShouldNormalizeTypedVariables(t *testing.T) {
+	assert := assert.New(t)
+
+	metas := VarMetas{
+		"NUM":  {Type: VarTypeNumber},
+		"BOOL": {Type: VarTypeBool},
+		"LIST": {Type: VarTypeList},
+	}
+
+	variables := Variables{"NUM": " 10 ", "BOOL": "T", "LIST": `["a", "b"]`}
+	assert.NoError(metas.Normalize(variables))
+	assert.Equal("10", variables["NUM"])
+	assert.Equal("true", variables["BOOL"])
+	assert.Equal("a\nb", variables["LIST"])
+
+	variables["BOOL"] = "not bool"
+	assert.Error(metas.Normalize(variables))
+}
+
+// the env variable tests should not depend on the user of host
+func setTestUser() {
+	if os.Getenv("USER") == "" {
+		_ = os.Setenv("USER", "flowci")
+	}
+}
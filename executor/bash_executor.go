@@ -8,27 +8,59 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
+
+	"github.com/creack/pty"
 )
 
 type (
 	BashExecutor struct {
 		BaseExecutor
-		command *exec.Cmd
-		workDir string
-		envFile string
+		command    *exec.Cmd
+		workDir    string
+		envFile    string
+		outputFile string
+		varsDir    string
+		tty        *os.File // master of pseudo terminal
 	}
 )
 
 func (b *BashExecutor) Init() (out error) {
+	if b.varsErr != nil {
+		return b.varsErr
+	}
+
+	if out = b.writeFileVars(); out != nil {
+		return
+	}
+
+	if out = b.createOutputFile(); out != nil {
+		return
+	}
+
 	if util.IsEmptyString(b.workspace) {
 		b.workDir, out = ioutil.TempDir("", "agent_")
 		b.vars[domain.VarAgentJobDir] = b.workDir
 		return
 	}
 
-	b.workDir = filepath.Join(b.workspace, util.ParseString(b.FlowId()))
+	if b.workDir, out = jobDirOf(b.workspace, b.FlowId()); out != nil {
+		return
+	}
+
 	b.vars[domain.VarAgentJobDir] = b.workDir
-	out = os.MkdirAll(b.workDir, os.ModePerm)
+
+	if b.inCmd.CleanWorkspace {
+		if out = os.RemoveAll(b.workDir); out != nil {
+			return
+		}
+	}
+
+	if out = os.MkdirAll(b.workDir, os.ModePerm); out != nil {
+		return
+	}
+
+	out = touchDir(b.workDir)
 	return
 }
 
@@ -40,25 +72,39 @@ func (b *BashExecutor) Start() (out error) {
 			b.handleErrors(out)
 		}
 
+		if !util.IsEmptyString(b.varsDir) {
+			_ = os.RemoveAll(b.varsDir)
+		}
+
+		if !util.IsEmptyString(b.outputFile) {
+			_ = os.Remove(b.outputFile)
+		}
+
+		// close tty after rest of output been read
+		if b.tty != nil {
+			util.Wait(b.stdOutWg, defaultLogWaitingDuration)
+			_ = b.tty.Close()
+		}
+
 		b.closeChannels()
 	}()
 
-	// init wait group fro StdOut and StdErr
-	b.stdOutWg.Add(2)
+	env := os.Environ()
+	if b.pty != nil {
+		env = append(env, "TERM="+ptyTerm)
+	}
 
 	command := exec.Command(linuxBash)
 	command.Dir = b.workDir
-	command.Env = append(os.Environ(), b.vars.ToStringArray()...)
+	command.Env = append(env, b.envArray()...)
 
 	stdin, _ := command.StdinPipe()
-	stdout, _ := command.StdoutPipe()
-	stderr, _ := command.StderrPipe()
 
 	b.command = command
 	b.startToHandleContext()
 
 	// start command
-	if err := command.Start(); err != nil {
+	if err := b.startCommand(); err != nil {
 		return b.toErrorStatus(err)
 	}
 
@@ -66,13 +112,11 @@ func (b *BashExecutor) Start() (out error) {
 		tmpFile, err := ioutil.TempFile("", "agent_env_")
 
 		if err == nil {
-			in <- "env > " + tmpFile.Name()
+			in <- "env -0 > " + tmpFile.Name()
 			b.envFile = tmpFile.Name()
 		}
 	}
 
-	b.writeLog(stdout, true)
-	b.writeLog(stderr, true)
 	b.writeCmd(stdin, nil, writeEnv)
 	b.toStartStatus(command.Process.Pid)
 
@@ -81,6 +125,7 @@ func (b *BashExecutor) Start() (out error) {
 	util.LogDebug("[Done]: Shell for %s", b.CmdId())
 
 	b.exportEnv()
+	b.exportOutput()
 
 	if b.CmdResult.IsFinishStatus() {
 		return nil
@@ -95,6 +140,54 @@ func (b *BashExecutor) Start() (out error) {
 //	private
 //====================================================================
 
+// start command and read log from stdout and stderr, which share the pseudo terminal if pty enabled
+func (b *BashExecutor) startCommand() error {
+	command := b.command
+
+	if b.pty == nil {
+		stdout, _ := command.StdoutPipe()
+		stderr, _ := command.StderrPipe()
+
+		if err := command.Start(); err != nil {
+			return err
+		}
+
+		b.stdOutWg.Add(2)
+		b.writeLog(stdout, domain.LogStream_STDOUT, true)
+		b.writeLog(stderr, domain.LogStream_STDERR, true)
+		return nil
+	}
+
+	master, slave, err := pty.Open()
+	if err != nil {
+		return err
+	}
+
+	// the slave is kept by bash process after started
+	defer slave.Close()
+
+	err = pty.Setsize(master, &pty.Winsize{Cols: b.pty.Cols, Rows: b.pty.Rows})
+	if err != nil {
+		_ = master.Close()
+		return err
+	}
+
+	// stdin is still a pipe, so bash is not interactive and the scripts are not echoed
+	command.Stdout = slave
+	command.Stderr = slave
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+
+	if err = command.Start(); err != nil {
+		_ = master.Close()
+		return err
+	}
+
+	b.tty = master
+	b.stdOutWg.Add(1)
+	b.writeLog(master, domain.LogStream_STDOUT, true)
+	return nil
+}
+
 func (b *BashExecutor) exportEnv() {
 	if util.IsEmptyString(b.envFile) {
 		return
@@ -106,7 +199,58 @@ func (b *BashExecutor) exportEnv() {
 	}
 
 	defer file.Close()
-	b.CmdResult.Output = readEnvFromReader(file, b.inCmd.EnvFilters)
+	b.CmdResult.Output = b.filterOutput(readEnvFromReader(file, b.inCmd.EnvFilters))
+}
+
+// merge outputs written to FLOWCI_OUTPUT file
+func (b *BashExecutor) exportOutput() {
+	if util.IsEmptyString(b.outputFile) {
+		return
+	}
+
+	file, err := os.Open(b.outputFile)
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+	b.mergeOutput(readOutputFromReader(file))
+}
+
+func (b *BashExecutor) createOutputFile() error {
+	file, err := ioutil.TempFile("", "agent_output_")
+	if err != nil {
+		return err
+	}
+
+	b.outputFile = file.Name()
+	b.vars[domain.VarOutputFile] = b.outputFile
+	return file.Close()
+}
+
+// write content of file vars to temp dir, and replace var value to file path
+func (b *BashExecutor) writeFileVars() error {
+	if len(b.inCmd.Meta.Names(domain.VarTypeFile)) == 0 {
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "agent_vars_")
+	if err != nil {
+		return err
+	}
+
+	b.varsDir = dir
+	files := b.fileVars(func(name string) string {
+		return filepath.Join(dir, name)
+	})
+
+	for path, content := range files {
+		if err = ioutil.WriteFile(path, content, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (b *BashExecutor) startToHandleContext() {
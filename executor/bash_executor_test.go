@@ -1,9 +1,14 @@
 package executor
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -48,7 +53,8 @@ func TestShouldExitByKill(t *testing.T) {
 func createBashTestCmd() *domain.CmdIn {
 	return &domain.CmdIn{
 		Cmd: domain.Cmd{
-			ID: "1-1-1",
+			ID:     "1-1-1",
+			FlowId: "flowid", // same as dir flowid in _testdata
 		},
 		Scripts: []string{
 			"set -e",
@@ -63,3 +69,186 @@ func createBashTestCmd() *domain.CmdIn {
 		EnvFilters: []string{"FLOW_"},
 	}
 }
+
+func TestShouldWriteFileVarAndMaskSecretInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := &domain.CmdIn{
+		Cmd: domain.Cmd{
+			ID:     "1-1-1",
+			FlowId: "flowid",
+		},
+		Scripts: []string{
+			"echo $MY_TOKEN",
+			"sleep 1",
+			"export FLOW_KEY=$(cat $MY_KEY)",
+		},
+		Inputs: domain.Variables{"MY_TOKEN": "secret-token", "MY_KEY": "key-content"},
+		Meta: domain.VarMetas{
+			"MY_TOKEN": {Type: domain.VarTypeSecret},
+			"MY_KEY":   {Type: domain.VarTypeFile},
+		},
+		Timeout:    1800,
+		EnvFilters: []string{"FLOW_", "MY_"},
+	}
+
+	executor := newExecutor(cmd)
+	assert.NoError(executor.Init())
+
+	var logs []byte
+	done := make(chan bool)
+	go func() {
+		for item := range executor.LogChannel() {
+			logs = append(logs, item.Content...)
+		}
+		done <- true
+	}()
+
+	assert.NoError(executor.Start())
+	<-done
+
+	result := executor.GetResult()
+	assert.Equal(0, result.Code)
+	assert.Equal("key-content", result.Output["FLOW_KEY"])
+	assert.NotContains(result.Output, "MY_TOKEN")
+	assert.NotContains(string(logs), "secret-token")
+	assert.Contains(string(logs), "******")
+}
+
+func TestShouldExportMultiLineEnvInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createBashTestCmd()
+	cmd.Scripts = []string{
+		"export FLOW_MULTI_LINE=$'line 1\\nline 2'",
+		"hello() { echo hello; }",
+		"export -f hello",
+	}
+
+	executor := newExecutor(cmd)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.LogChannel())
+	assert.NoError(executor.Start())
+
+	output := executor.GetResult().Output
+	assert.Equal("line 1\nline 2", output["FLOW_MULTI_LINE"])
+}
+
+func TestShouldMergeOutputFileInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createBashTestCmd()
+	cmd.Scripts = []string{
+		"export FLOW_VVV=from_env",
+		"(echo \"FLOW_VVV=from_output\" >> $FLOWCI_OUTPUT)",
+		"printf 'NOTES<<EOF\\nhello\\nworld\\nEOF\\n' >> $FLOWCI_OUTPUT",
+	}
+
+	executor := newExecutor(cmd)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.LogChannel())
+	assert.NoError(executor.Start())
+
+	output := executor.GetResult().Output
+	assert.Equal("from_output", output["FLOW_VVV"])
+	assert.Equal("hello\nworld", output["NOTES"])
+}
+
+func TestShouldRunHooksInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "agent_hooks_test_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cmd := createBashTestCmd()
+	cmd.Scripts = []string{"echo run", "notCommand should exit with error"}
+
+	executor := NewExecutor(Options{
+		Parent: context.Background(),
+		Cmd:    cmd,
+		Hooks: Hooks{
+			PreCmd:    "echo pre > " + filepath.Join(dir, "pre"),
+			PostCmd:   "echo $FLOWCI_CMD_ID > " + filepath.Join(dir, "post"),
+			OnFailure: "echo $FLOWCI_CMD_EXIT_CODE > " + filepath.Join(dir, "failure"),
+		},
+	})
+	assert.NoError(executor.Init())
+
+	go printLog(executor.LogChannel())
+	assert.NoError(executor.Start())
+	assert.Equal(127, executor.GetResult().Code)
+
+	read := func(name string) string {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return strings.TrimSpace(string(content))
+	}
+
+	assert.Equal("pre", read("pre"))
+	assert.Equal("1-1-1", read("post"))
+	assert.Equal("127", read("failure"))
+}
+
+func TestShouldRunInPtyInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createBashTestCmd()
+	cmd.Pty = &domain.PtyOption{Cols: 120}
+	cmd.Scripts = []string{
+		"[ -t 1 ] && echo stdout is tty",
+		"[ -t 0 ] || echo stdin is not tty",
+		"echo size $(stty size < /dev/tty)",
+		"export FLOW_TERM=$TERM",
+	}
+
+	executor := newExecutor(cmd)
+	assert.NoError(executor.Init())
+
+	var content strings.Builder
+	done := make(chan struct{})
+
+	go func() {
+		for log := range executor.LogChannel() {
+			assert.Equal(domain.LogStream_STDOUT, log.Stream)
+			content.Write(log.Content)
+		}
+		close(done)
+	}()
+
+	assert.NoError(executor.Start())
+	<-done
+
+	assert.Equal(domain.CmdStatusSuccess, executor.GetResult().Status)
+	assert.Equal(ptyTerm, executor.GetResult().Output["FLOW_TERM"])
+
+	log := content.String()
+	assert.Contains(log, "stdout is tty\r\n")
+	assert.Contains(log, "stdin is not tty\r\n")
+	assert.Contains(log, "size 24 120\r\n")
+	assert.NotContains(log, "echo size")
+}
+
+func TestShouldNotCleanWorkspaceIfFlowIdMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	workspace, _ := ioutil.TempDir("", "agent_ws_")
+	defer os.RemoveAll(workspace)
+
+	dbFile := filepath.Join(workspace, "agent.db")
+	_ = ioutil.WriteFile(dbFile, []byte("db"), 0644)
+
+	cmd := createBashTestCmd()
+	cmd.FlowId = ""
+	cmd.CleanWorkspace = true
+
+	executor := NewExecutor(Options{
+		Parent:    context.Background(),
+		Workspace: workspace,
+		Cmd:       cmd,
+	})
+
+	assert.Equal(ErrorFlowIdMissing, executor.Init())
+	assert.FileExists(dbFile)
+}
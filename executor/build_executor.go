@@ -0,0 +1,551 @@
+package executor
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-connections/tlsconfig"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	defaultDockerfile   = "Dockerfile"
+	dockerIgnoreFile    = ".dockerignore"
+	defaultRegistryHost = "https://index.docker.io/v1/"
+
+	// min api version which supports build target
+	dockerBuildTargetVersion = "1.29"
+)
+
+var (
+	builtImagePattern = regexp.MustCompile(`Successfully built ([0-9a-f]+)`)
+)
+
+type (
+	// BuildExecutor build docker image from context dir in job dir by docker api
+	BuildExecutor struct {
+		BaseExecutor
+		cli        *client.Client
+		contextDir string
+		option     domain.BuildOption
+		imageId    string
+		digests    []string
+	}
+
+	// json message from docker build and push api
+	dockerMessage struct {
+		Stream   string           `json:"stream"`
+		Status   string           `json:"status"`
+		Progress string           `json:"progress"`
+		ID       string           `json:"id"`
+		Error    string           `json:"error"`
+		Aux      *json.RawMessage `json:"aux"`
+	}
+)
+
+func (b *BuildExecutor) Init() (out error) {
+	if b.varsErr != nil {
+		return b.varsErr
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			out = err.(error)
+		}
+	}()
+
+	if !b.inCmd.HasBuildOption() {
+		return ErrorBuildOptionMissing
+	}
+
+	b.option = b.resolveOption(*b.inCmd.Build)
+
+	if b.option.Push && len(b.option.Tags) == 0 {
+		return ErrorBuildTagsMissingToPush
+	}
+
+	jobDir, err := b.initJobDir()
+	util.PanicIfErr(err)
+
+	b.contextDir = filepath.Join(jobDir, b.option.Context)
+	if !isInsideDir(jobDir, b.contextDir) {
+		return ErrorBuildContextOutside
+	}
+
+	b.cli, err = client.NewEnvClient()
+	return err
+}
+
+func (b *BuildExecutor) Start() (out error) {
+	defer func() {
+		if err := recover(); err != nil {
+			out = b.toStatusOfError(err.(error))
+		}
+
+		if b.cli != nil {
+			_ = b.cli.Close()
+		}
+
+		b.closeChannels()
+	}()
+
+	b.toStartStatus(os.Getpid())
+	b.writeSingleLog(fmt.Sprintf("Build image from %s\n", b.contextDir))
+
+	b.build()
+
+	if b.option.Push {
+		for _, tag := range b.option.Tags {
+			b.push(tag)
+		}
+	}
+
+	b.exportImage()
+	b.toFinishStatus(domain.CmdExitCodeSuccess)
+	return
+}
+
+//====================================================================
+//	private
+//====================================================================
+
+// resolve option value from variables, since credential could be from secret vars
+func (b *BuildExecutor) resolveOption(option domain.BuildOption) domain.BuildOption {
+	parse := func(val string) string {
+		return util.ParseStringWithSource(val, b.vars)
+	}
+
+	option.Context = parse(option.Context)
+	option.Dockerfile = parse(option.Dockerfile)
+	option.Target = parse(option.Target)
+
+	if util.IsEmptyString(option.Dockerfile) {
+		option.Dockerfile = defaultDockerfile
+	}
+
+	args := make(map[string]string, len(option.Args))
+	for k, v := range option.Args {
+		args[k] = parse(v)
+	}
+	option.Args = args
+
+	tags := make([]string, len(option.Tags))
+	for i, tag := range option.Tags {
+		tags[i] = parse(tag)
+	}
+	option.Tags = tags
+
+	cacheFrom := make([]string, len(option.CacheFrom))
+	for i, image := range option.CacheFrom {
+		cacheFrom[i] = parse(image)
+	}
+	option.CacheFrom = cacheFrom
+
+	if option.Auth != nil {
+		option.Auth = &domain.RegistryAuth{
+			Username:      parse(option.Auth.Username),
+			Password:      parse(option.Auth.Password),
+			ServerAddress: parse(option.Auth.ServerAddress),
+		}
+	}
+
+	return option
+}
+
+func (b *BuildExecutor) build() {
+	excludes, err := readDockerIgnore(b.contextDir)
+	util.PanicIfErr(err)
+
+	args := make(map[string]*string, len(b.option.Args))
+	for k := range b.option.Args {
+		v := b.option.Args[k]
+		args[k] = &v
+	}
+
+	options := types.ImageBuildOptions{
+		Tags:        b.option.Tags,
+		Dockerfile:  b.option.Dockerfile,
+		BuildArgs:   args,
+		CacheFrom:   b.option.CacheFrom,
+		NoCache:     b.option.NoCache,
+		PullParent:  b.option.Pull,
+		Remove:      true,
+		ForceRemove: true,
+		Labels:      map[string]string{domain.DockerLabelAgent: b.agentId},
+	}
+
+	if auth := b.authConfig(); auth != nil {
+		options.AuthConfigs = map[string]types.AuthConfig{auth.ServerAddress: *auth}
+	}
+
+	buildContext := tarArchiveFromDir(b.contextDir, b.option.Dockerfile, excludes)
+
+	var body io.ReadCloser
+	if util.IsEmptyString(b.option.Target) {
+		resp, err := b.cli.ImageBuild(b.context, buildContext, options)
+		util.PanicIfErr(err)
+		body = resp.Body
+	} else {
+		body, err = imageBuildWithTarget(b.context, buildContext, options, b.option.Target)
+		util.PanicIfErr(err)
+	}
+	defer body.Close()
+
+	b.readMessages(body, func(aux json.RawMessage) {
+		var result struct{ ID string }
+		if json.Unmarshal(aux, &result) == nil && !util.IsEmptyString(result.ID) {
+			b.imageId = result.ID
+		}
+	})
+
+	// the aux message not available on old docker daemon
+	if util.IsEmptyString(b.imageId) && len(b.option.Tags) > 0 {
+		inspect, _, err := b.cli.ImageInspectWithRaw(b.context, b.option.Tags[0])
+		util.PanicIfErr(err)
+		b.imageId = inspect.ID
+	}
+}
+
+func (b *BuildExecutor) push(tag string) {
+	b.writeSingleLog(fmt.Sprintf("Push image %s\n", tag))
+
+	options := types.ImagePushOptions{
+		// registry auth header is required even if it's empty
+		RegistryAuth: base64.URLEncoding.EncodeToString([]byte("{}")),
+	}
+
+	if auth := b.authConfig(); auth != nil {
+		raw, err := json.Marshal(auth)
+		util.PanicIfErr(err)
+		options.RegistryAuth = base64.URLEncoding.EncodeToString(raw)
+	}
+
+	reader, err := b.cli.ImagePush(b.context, tag, options)
+	util.PanicIfErr(err)
+	defer reader.Close()
+
+	b.readMessages(reader, func(aux json.RawMessage) {
+		var result struct{ Tag, Digest string }
+		if json.Unmarshal(aux, &result) == nil && !util.IsEmptyString(result.Digest) {
+			b.digests = append(b.digests, tag+"@"+result.Digest)
+		}
+	})
+}
+
+// read json messages from docker, write to log and panic on error message
+func (b *BuildExecutor) readMessages(reader io.Reader, onAux func(aux json.RawMessage)) {
+	decoder := json.NewDecoder(reader)
+
+	for {
+		var message dockerMessage
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return
+			}
+			panic(err)
+		}
+
+		if !util.IsEmptyString(message.Error) {
+			panic(fmt.Errorf("agent: %s", message.Error))
+		}
+
+		if message.Aux != nil {
+			onAux(*message.Aux)
+		}
+
+		if !util.IsEmptyString(message.Stream) {
+			b.writeLogItem([]byte(message.Stream))
+
+			if match := builtImagePattern.FindStringSubmatch(message.Stream); len(match) == 2 && util.IsEmptyString(b.imageId) {
+				b.imageId = match[1]
+			}
+			continue
+		}
+
+		// progress detail is skipped since too many lines
+		if !util.IsEmptyString(message.Status) && util.IsEmptyString(message.Progress) {
+			if util.IsEmptyString(message.ID) {
+				b.writeLogItem([]byte(message.Status + util.UnixLineBreakStr))
+			} else {
+				b.writeLogItem([]byte(message.ID + ": " + message.Status + util.UnixLineBreakStr))
+			}
+		}
+	}
+}
+
+func (b *BuildExecutor) authConfig() *types.AuthConfig {
+	auth := b.option.Auth
+	if auth == nil {
+		return nil
+	}
+
+	server := auth.ServerAddress
+	if util.IsEmptyString(server) {
+		server = defaultRegistryHost
+	}
+
+	return &types.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		ServerAddress: server,
+	}
+}
+
+func (b *BuildExecutor) exportImage() {
+	output := b.CmdResult.Output
+	output[domain.VarBuildImageId] = b.imageId
+	output[domain.VarBuildImageTags] = strings.Join(b.option.Tags, util.UnixLineBreakStr)
+	output[domain.VarBuildImageDigests] = strings.Join(b.digests, util.UnixLineBreakStr)
+}
+
+// read exclude patterns from .dockerignore in context dir
+func readDockerIgnore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, dockerIgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var excludes []string
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if util.IsEmptyString(line) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		excludes = append(excludes, filepath.Clean(strings.TrimPrefix(line, "/")))
+	}
+
+	return excludes, scanner.Err()
+}
+
+// the file or any parent dir matches exclude pattern
+func isExcluded(rel string, excludes []string) bool {
+	for path := rel; path != "." && path != string(filepath.Separator); path = filepath.Dir(path) {
+		for _, pattern := range excludes {
+			if ok, _ := filepath.Match(pattern, path); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tar archive of context dir which is written in background, relative path is used as file name
+func tarArchiveFromDir(dir, dockerfile string, excludes []string) io.Reader {
+	reader, writer := io.Pipe()
+	dockerfile = filepath.Clean(filepath.FromSlash(dockerfile))
+
+	go func() {
+		tw := tar.NewWriter(writer)
+
+		err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, file)
+			if err != nil || rel == "." {
+				return err
+			}
+
+			// the dockerfile and .dockerignore are always sent to daemon
+			if rel != dockerfile && rel != dockerIgnoreFile && isExcluded(rel, excludes) {
+				// walk into the excluded dir which contains dockerfile
+				if fi.IsDir() && !strings.HasPrefix(dockerfile, rel+string(filepath.Separator)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return err
+			}
+
+			header.Name = filepath.ToSlash(rel)
+			if err = tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(tw, f)
+			return err
+		})
+
+		if err == nil {
+			err = tw.Close()
+		}
+
+		_ = writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+// post build request with target, since the ImageBuildOptions of docker client v1.13.1 has no target,
+// the http client is configured from env as same as client.NewEnvClient
+func imageBuildWithTarget(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions, target string) (io.ReadCloser, error) {
+	httpClient, baseUrl, err := dockerHttpClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := imageBuildQuery(options)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("target", target)
+
+	version := os.Getenv("DOCKER_API_VERSION")
+	if util.IsEmptyString(version) {
+		version = dockerBuildTargetVersion
+	}
+
+	path := fmt.Sprintf("%s/v%s/build?%s", baseUrl, strings.TrimPrefix(version, "v"), query.Encode())
+	req, err := http.NewRequest(http.MethodPost, path, buildContext)
+	if err != nil {
+		return nil, err
+	}
+
+	authConfigs, err := json.Marshal(options.AuthConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Registry-Config", base64.URLEncoding.EncodeToString(authConfigs))
+	req.Header.Set("Content-Type", "application/tar")
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("agent: unable to build image: %s", strings.TrimSpace(string(msg)))
+	}
+
+	return resp.Body, nil
+}
+
+// query of build options which are used by build executor
+func imageBuildQuery(options types.ImageBuildOptions) (url.Values, error) {
+	query := url.Values{"t": options.Tags}
+	query.Set("dockerfile", options.Dockerfile)
+	query.Set("rm", "0")
+
+	if options.Remove {
+		query.Set("rm", "1")
+	}
+
+	if options.ForceRemove {
+		query.Set("forcerm", "1")
+	}
+
+	if options.NoCache {
+		query.Set("nocache", "1")
+	}
+
+	if options.PullParent {
+		query.Set("pull", "1")
+	}
+
+	jsonParams := map[string]interface{}{
+		"buildargs": options.BuildArgs,
+		"labels":    options.Labels,
+		"cachefrom": options.CacheFrom,
+	}
+
+	for name, val := range jsonParams {
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		query.Set(name, string(raw))
+	}
+
+	return query, nil
+}
+
+// http client and base url of docker daemon from DOCKER_HOST and DOCKER_CERT_PATH
+func dockerHttpClientFromEnv() (*http.Client, string, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if util.IsEmptyString(host) {
+		host = client.DefaultDockerHost
+	}
+
+	proto, addr, basePath, err := client.ParseHost(host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scheme := "http"
+	transport := new(http.Transport)
+
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); !util.IsEmptyString(certPath) {
+		tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             filepath.Join(certPath, "ca.pem"),
+			CertFile:           filepath.Join(certPath, "cert.pem"),
+			KeyFile:            filepath.Join(certPath, "key.pem"),
+			InsecureSkipVerify: os.Getenv("DOCKER_TLS_VERIFY") == "",
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if err = sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, "", err
+	}
+
+	// the url host is not used by unix socket or named pipe
+	if proto != "tcp" {
+		addr = "docker"
+	}
+
+	return &http.Client{Transport: transport}, fmt.Sprintf("%s://%s%s", scheme, addr, basePath), nil
+}
@@ -0,0 +1,171 @@
+package executor
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github/flowci/flow-agent-x/domain"
+)
+
+func TestShouldTarBuildContextWithDockerIgnore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "agent_build_ctx_")
+	defer os.RemoveAll(dir)
+
+	_ = os.MkdirAll(filepath.Join(dir, "src"), os.ModePerm)
+	_ = os.MkdirAll(filepath.Join(dir, "node_modules", "lib"), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM alpine"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("# comment\nnode_modules\n*.log\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "debug.log"), []byte("log"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "node_modules", "lib", "index.js"), []byte("js"), 0644)
+
+	excludes, err := readDockerIgnore(dir)
+	assert.NoError(err)
+	assert.Equal([]string{"node_modules", "*.log"}, excludes)
+
+	names := make(map[string]bool)
+	reader := tar.NewReader(tarArchiveFromDir(dir, defaultDockerfile, excludes))
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(err)
+		names[header.Name] = true
+	}
+
+	assert.True(names["Dockerfile"])
+	assert.True(names[".dockerignore"])
+	assert.True(names["src/main.go"])
+	assert.False(names["debug.log"])
+	assert.False(names["node_modules"])
+	assert.False(names["node_modules/lib/index.js"])
+}
+
+func TestShouldTarCustomDockerfileIgnoredByDockerIgnore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "agent_build_ctx_")
+	defer os.RemoveAll(dir)
+
+	_ = os.MkdirAll(filepath.Join(dir, "docker"), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(dir, "docker", "app.Dockerfile"), []byte("FROM alpine"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "docker", "notes.txt"), []byte("notes"), 0644)
+
+	names := make(map[string]bool)
+	reader := tar.NewReader(tarArchiveFromDir(dir, "./docker/app.Dockerfile", []string{"docker"}))
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(err)
+		names[header.Name] = true
+	}
+
+	assert.True(names["docker/app.Dockerfile"])
+	assert.False(names["docker/notes.txt"])
+}
+
+func TestShouldFailBuildIfContextOutsideJobDir(t *testing.T) {
+	assert := assert.New(t)
+
+	workspace, _ := ioutil.TempDir("", "agent_build_ws_")
+	defer os.RemoveAll(workspace)
+
+	executor := NewExecutor(Options{
+		Parent:    context.Background(),
+		Workspace: workspace,
+		Cmd: &domain.CmdIn{
+			Cmd: domain.Cmd{
+				ID:     "1-1-1",
+				FlowId: "flowid",
+			},
+			Type:    domain.CmdTypeBuild,
+			Timeout: 60,
+			Build: &domain.BuildOption{
+				Context: "../../",
+			},
+		},
+	})
+
+	assert.Equal(ErrorBuildContextOutside, executor.Init())
+}
+
+func TestShouldBuildWithTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	var query map[string][]string
+	var dockerfile []byte
+
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v1.29/build", r.URL.Path)
+		query = r.URL.Query()
+
+		reader := tar.NewReader(r.Body)
+		for {
+			header, err := reader.Next()
+			if err != nil {
+				break
+			}
+
+			if header.Name == "Dockerfile" {
+				dockerfile, _ = ioutil.ReadAll(reader)
+			}
+		}
+
+		_, _ = w.Write([]byte(`{"stream":"Step 1/2"}` + "\n" + `{"aux":{"ID":"sha256:abc"}}` + "\n"))
+	}))
+	defer daemon.Close()
+
+	_ = os.Setenv("DOCKER_HOST", "tcp://"+daemon.Listener.Addr().String())
+	defer os.Unsetenv("DOCKER_HOST")
+
+	workspace, _ := ioutil.TempDir("", "agent_build_ws_")
+	defer os.RemoveAll(workspace)
+
+	_ = os.MkdirAll(filepath.Join(workspace, "flowid"), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(workspace, "flowid", "Dockerfile"), []byte("FROM alpine AS base"), 0644)
+
+	executor := NewExecutor(Options{
+		Parent:    context.Background(),
+		Workspace: workspace,
+		Cmd: &domain.CmdIn{
+			Cmd: domain.Cmd{
+				ID:     "1-1-1",
+				FlowId: "flowid",
+			},
+			Type:    domain.CmdTypeBuild,
+			Timeout: 60,
+			Build: &domain.BuildOption{
+				Target: "base",
+				Tags:   []string{"flowci/test:1.0"},
+			},
+		},
+	})
+
+	assert.NoError(executor.Init())
+	go printLog(executor.LogChannel())
+
+	assert.NoError(executor.Start())
+	assert.Equal(domain.CmdStatusSuccess, executor.GetResult().Status)
+	assert.Equal("sha256:abc", executor.GetResult().Output[domain.VarBuildImageId])
+
+	assert.Equal([]string{"base"}, query["target"])
+	assert.Equal([]string{"flowci/test:1.0"}, query["t"])
+	assert.Equal("FROM alpine AS base", string(dockerfile))
+}
@@ -0,0 +1,440 @@
+package executor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	git "gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	gitRemoteName      = "origin"
+	gitDefaultUsername = "git"
+
+	// print passphrase of ssh key from env for git cli
+	gitAskPassScript = "#!/bin/sh\necho \"$FLOW_GIT_PASSPHRASE\"\n"
+)
+
+var (
+	gitRefSpecs = []gitconfig.RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/tags/*:refs/tags/*",
+	}
+)
+
+type (
+	// CheckoutExecutor checkout git repo to job dir, the existing clone will be reused
+	CheckoutExecutor struct {
+		BaseExecutor
+		workDir string
+		option  domain.CheckoutOption
+		auth    transport.AuthMethod
+	}
+
+	// write git progress to log channel
+	logWriter struct {
+		b *BaseExecutor
+	}
+)
+
+func (c *CheckoutExecutor) Init() (out error) {
+	if c.varsErr != nil {
+		return c.varsErr
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			out = err.(error)
+		}
+	}()
+
+	if !c.inCmd.HasCheckoutOption() {
+		return ErrorCheckoutOptionMissing
+	}
+
+	option, err := c.resolveOption(*c.inCmd.Checkout)
+	if err != nil {
+		return err
+	}
+
+	c.option = option
+
+	if util.IsEmptyString(c.option.Url) {
+		return ErrorCheckoutUrlMissing
+	}
+
+	c.auth = c.initAuth(c.option.Credential)
+
+	jobDir, err := c.initJobDir()
+	util.PanicIfErr(err)
+
+	c.workDir = filepath.Join(jobDir, c.option.Dir)
+	if !isInsideDir(jobDir, c.workDir) {
+		return ErrorCheckoutDirOutside
+	}
+
+	return os.MkdirAll(c.workDir, os.ModePerm)
+}
+
+func (c *CheckoutExecutor) Start() (out error) {
+	defer func() {
+		if err := recover(); err != nil {
+			out = c.toStatusOfError(err.(error))
+		}
+
+		c.closeChannels()
+	}()
+
+	c.toStartStatus(os.Getpid())
+	c.writeSingleLog(fmt.Sprintf("Checkout %s to %s\n", c.option.Url, c.workDir))
+
+	repo := c.openOrInit()
+	c.fetch(repo)
+
+	hash, branch := c.resolveTarget(repo)
+	c.checkout(repo, hash)
+
+	if c.option.Submodules {
+		c.updateSubmodules(repo)
+	}
+
+	if c.option.Lfs {
+		c.pullLfs()
+	}
+
+	c.exportCommit(repo, hash, branch)
+	c.toFinishStatus(domain.CmdExitCodeSuccess)
+	return
+}
+
+//====================================================================
+//	private
+//====================================================================
+
+// resolve option value from variables, since credential could be from secret vars
+func (c *CheckoutExecutor) resolveOption(option domain.CheckoutOption) (out domain.CheckoutOption, err error) {
+	lookup := func(name string) (string, bool) {
+		val, ok := c.vars[name]
+		return val, ok
+	}
+
+	// keep the first error, e.g. ${VAR:?msg} on missing variable
+	parse := func(val string) string {
+		expanded, expandErr := util.ExpandString(val, lookup)
+		if expandErr != nil && err == nil {
+			err = expandErr
+		}
+		return expanded
+	}
+
+	option.Url = parse(option.Url)
+	option.Ref = parse(option.Ref)
+	option.Commit = parse(option.Commit)
+	option.Dir = parse(option.Dir)
+
+	if option.Credential != nil {
+		option.Credential = &domain.GitCredential{
+			SshKey:     parse(option.Credential.SshKey),
+			Passphrase: parse(option.Credential.Passphrase),
+			Username:   parse(option.Credential.Username),
+			Token:      parse(option.Credential.Token),
+
+			InsecureIgnoreHostKey: option.Credential.InsecureIgnoreHostKey,
+		}
+	}
+
+	return option, err
+}
+
+func (c *CheckoutExecutor) initAuth(credential *domain.GitCredential) transport.AuthMethod {
+	if credential == nil {
+		return nil
+	}
+
+	username := credential.Username
+	if util.IsEmptyString(username) {
+		username = gitDefaultUsername
+	}
+
+	if !util.IsEmptyString(credential.SshKey) {
+		auth, err := gitssh.NewPublicKeys(username, []byte(credential.SshKey), credential.Passphrase)
+		util.PanicIfErr(err)
+
+		// the nil callback falls back to known_hosts from SSH_KNOWN_HOSTS or ~/.ssh/known_hosts
+		if credential.InsecureIgnoreHostKey {
+			util.LogWarn("Cmd '%s' checkout without ssh host key checking", c.inCmd.ID)
+			auth.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+		}
+		return auth
+	}
+
+	if !util.IsEmptyString(credential.Token) {
+		return &githttp.BasicAuth{
+			Username: username,
+			Password: credential.Token,
+		}
+	}
+
+	return nil
+}
+
+func (c *CheckoutExecutor) openOrInit() *git.Repository {
+	url := c.option.Url
+
+	repo, err := git.PlainOpen(c.workDir)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(c.workDir, false)
+		util.PanicIfErr(err)
+
+		_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+			Name:  gitRemoteName,
+			URLs:  []string{url},
+			Fetch: gitRefSpecs,
+		})
+		util.PanicIfErr(err)
+		return repo
+	}
+
+	util.PanicIfErr(err)
+	c.writeSingleLog("Reuse existing repo\n")
+
+	// update remote url if url been changed
+	remote, err := repo.Remote(gitRemoteName)
+	if err == nil && remote.Config().URLs[0] == url {
+		return repo
+	}
+
+	_ = repo.DeleteRemote(gitRemoteName)
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name:  gitRemoteName,
+		URLs:  []string{url},
+		Fetch: gitRefSpecs,
+	})
+	util.PanicIfErr(err)
+	return repo
+}
+
+func (c *CheckoutExecutor) fetch(repo *git.Repository) {
+	err := repo.FetchContext(c.context, &git.FetchOptions{
+		RemoteName: gitRemoteName,
+		RefSpecs:   gitRefSpecs,
+		Depth:      c.option.Depth,
+		Auth:       c.auth,
+		Progress:   &logWriter{b: &c.BaseExecutor},
+		Tags:       git.AllTags,
+		Force:      true,
+	})
+
+	if err == git.NoErrAlreadyUpToDate {
+		return
+	}
+
+	util.PanicIfErr(err)
+}
+
+// resolve commit hash and branch name from option, the default is remote HEAD
+func (c *CheckoutExecutor) resolveTarget(repo *git.Repository) (plumbing.Hash, string) {
+	if !util.IsEmptyString(c.option.Commit) {
+		hash, err := repo.ResolveRevision(plumbing.Revision(c.option.Commit))
+		if err != nil {
+			panic(fmt.Errorf("agent: commit '%s' not found, depth might be too small", c.option.Commit))
+		}
+		return *hash, c.option.Ref
+	}
+
+	ref := c.option.Ref
+	if util.IsEmptyString(ref) {
+		ref = c.remoteHead(repo)
+	}
+
+	candidates := []plumbing.ReferenceName{
+		plumbing.ReferenceName("refs/remotes/" + gitRemoteName + "/" + ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+
+	for _, name := range candidates {
+		reference, err := repo.Reference(name, true)
+		if err != nil {
+			continue
+		}
+
+		hash := reference.Hash()
+
+		// annotated tag should point to commit
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+
+		return hash, ref
+	}
+
+	panic(fmt.Errorf("agent: git ref '%s' not found", ref))
+}
+
+// get default branch name from remote HEAD
+func (c *CheckoutExecutor) remoteHead(repo *git.Repository) string {
+	remote, err := repo.Remote(gitRemoteName)
+	util.PanicIfErr(err)
+
+	refs, err := remote.List(&git.ListOptions{Auth: c.auth})
+	util.PanicIfErr(err)
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target().Short()
+		}
+	}
+
+	return "master"
+}
+
+func (c *CheckoutExecutor) checkout(repo *git.Repository, hash plumbing.Hash) {
+	tree, err := repo.Worktree()
+	util.PanicIfErr(err)
+
+	// force checkout in detached mode which is a hard reset to the commit
+	err = tree.Checkout(&git.CheckoutOptions{
+		Hash:  hash,
+		Force: true,
+	})
+	util.PanicIfErr(err)
+
+	err = tree.Clean(&git.CleanOptions{Dir: true})
+	util.PanicIfErr(err)
+
+	c.writeSingleLog(fmt.Sprintf("HEAD is now at %s\n", hash.String()))
+}
+
+func (c *CheckoutExecutor) updateSubmodules(repo *git.Repository) {
+	tree, err := repo.Worktree()
+	util.PanicIfErr(err)
+
+	submodules, err := tree.Submodules()
+	util.PanicIfErr(err)
+
+	for _, sub := range submodules {
+		c.writeSingleLog(fmt.Sprintf("Update submodule %s\n", sub.Config().Name))
+
+		err = sub.UpdateContext(c.context, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              c.auth,
+		})
+		util.PanicIfErr(err)
+	}
+}
+
+// go-git not support lfs, run git lfs cli instead
+func (c *CheckoutExecutor) pullLfs() {
+	writer := &logWriter{b: &c.BaseExecutor}
+
+	env, cleanup := c.gitCliEnv()
+	defer cleanup()
+
+	for _, args := range [][]string{{"lfs", "install", "--local"}, {"lfs", "pull"}} {
+		command := exec.CommandContext(c.context, "git", args...)
+		command.Dir = c.workDir
+		command.Env = env
+		command.Stdout = writer
+		command.Stderr = writer
+
+		err := command.Run()
+		util.PanicIfErr(err)
+	}
+}
+
+// env of git cli with the same credential as go-git, the ssh key is written to temp dir removed by cleanup
+func (c *CheckoutExecutor) gitCliEnv() (env []string, cleanup func()) {
+	env = os.Environ()
+	cleanup = func() {}
+
+	credential := c.option.Credential
+	if credential == nil {
+		return
+	}
+
+	if !util.IsEmptyString(credential.SshKey) {
+		dir, err := ioutil.TempDir("", "agent_git_ssh_")
+		util.PanicIfErr(err)
+		cleanup = func() { _ = os.RemoveAll(dir) }
+
+		// ssh cli requires new line at end of key file which might be trimmed in secret
+		keyFile := filepath.Join(dir, "id_key")
+		key := strings.TrimSpace(credential.SshKey) + "\n"
+		util.PanicIfErr(ioutil.WriteFile(keyFile, []byte(key), 0600))
+
+		sshCmd := "ssh -o IdentitiesOnly=yes -i " + util.ShellQuote(keyFile)
+		if credential.InsecureIgnoreHostKey {
+			sshCmd += " -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCmd)
+
+		if !util.IsEmptyString(credential.Passphrase) {
+			askPass := filepath.Join(dir, "askpass.sh")
+			util.PanicIfErr(ioutil.WriteFile(askPass, []byte(gitAskPassScript), 0700))
+
+			env = append(env,
+				"SSH_ASKPASS="+askPass,
+				"SSH_ASKPASS_REQUIRE=force",
+				"DISPLAY=:0",
+				"FLOW_GIT_PASSPHRASE="+credential.Passphrase,
+			)
+		}
+		return
+	}
+
+	if !util.IsEmptyString(credential.Token) {
+		username := credential.Username
+		if util.IsEmptyString(username) {
+			username = gitDefaultUsername
+		}
+
+		// set by env instead of -c, so the token not shown in process args
+		basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + credential.Token))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraheader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+basic,
+		)
+	}
+
+	return
+}
+
+func (c *CheckoutExecutor) exportCommit(repo *git.Repository, hash plumbing.Hash, branch string) {
+	output := c.CmdResult.Output
+	output[domain.VarGitUrl] = c.option.Url
+	output[domain.VarGitBranch] = branch
+	output[domain.VarGitCommitId] = hash.String()
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return
+	}
+
+	output[domain.VarGitCommitMessage] = strings.TrimSpace(commit.Message)
+	output[domain.VarGitCommitAuthor] = commit.Author.Email
+	output[domain.VarGitCommitTime] = commit.Author.When.Format(time.RFC3339)
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	content := make([]byte, len(p))
+	copy(content, p)
+
+	w.b.writeLogItem(content)
+	return len(p), nil
+}
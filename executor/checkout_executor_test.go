@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github/flowci/flow-agent-x/domain"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+func TestShouldCheckoutAndReuseRepo(t *testing.T) {
+	assert := assert.New(t)
+
+	// init: source repo with one commit
+	source, _ := ioutil.TempDir("", "agent_git_src_")
+	defer os.RemoveAll(source)
+	commitId := createTestRepo(assert, source)
+
+	workspace, _ := ioutil.TempDir("", "agent_git_ws_")
+	defer os.RemoveAll(workspace)
+
+	for i := 0; i < 2; i++ {
+		// when:
+		executor := NewExecutor(Options{
+			Parent:    context.Background(),
+			Workspace: workspace,
+			Cmd:       createCheckoutTestCmd(source),
+		})
+		assert.NoError(executor.Init())
+
+		go printLog(executor.LogChannel())
+		assert.NoError(executor.Start())
+
+		// then:
+		result := executor.GetResult()
+		assert.Equal(domain.CmdStatusSuccess, result.Status)
+		assert.Equal(commitId, result.Output[domain.VarGitCommitId])
+		assert.Equal("master", result.Output[domain.VarGitBranch])
+		assert.Equal("init", result.Output[domain.VarGitCommitMessage])
+		assert.FileExists(filepath.Join(workspace, "flowid", "src", "hello.txt"))
+	}
+}
+
+func TestShouldFailCheckoutIfRefNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	source, _ := ioutil.TempDir("", "agent_git_src_")
+	defer os.RemoveAll(source)
+	createTestRepo(assert, source)
+
+	cmd := createCheckoutTestCmd(source)
+	cmd.Checkout.Ref = "not-existed"
+
+	executor := NewExecutor(Options{
+		Parent: context.Background(),
+		Cmd:    cmd,
+	})
+	assert.NoError(executor.Init())
+
+	go printLog(executor.LogChannel())
+	assert.Error(executor.Start())
+	assert.Equal(domain.CmdStatusException, executor.GetResult().Status)
+}
+
+func TestShouldFailCheckoutInitIfDirOutsideJobDir(t *testing.T) {
+	assert := assert.New(t)
+
+	workspace, _ := ioutil.TempDir("", "agent_git_ws_")
+	defer os.RemoveAll(workspace)
+
+	cmd := createCheckoutTestCmd("https://github.com/flowci/flow-agent-x.git")
+	cmd.Checkout.Dir = "../../outside"
+
+	executor := NewExecutor(Options{
+		Parent:    context.Background(),
+		Workspace: workspace,
+		Cmd:       cmd,
+	})
+	assert.Equal(ErrorCheckoutDirOutside, executor.Init())
+
+	cmd = createCheckoutTestCmd("${GIT_URL:?git url is required}")
+	executor = NewExecutor(Options{
+		Parent:    context.Background(),
+		Workspace: workspace,
+		Cmd:       cmd,
+	})
+	assert.Error(executor.Init())
+}
+
+func TestShouldCheckHostKeyUnlessInsecureEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createCheckoutTestCmd("git@github.com:flowci/flow-agent-x.git")
+	cmd.Checkout.Credential = &domain.GitCredential{SshKey: createTestSshKey(assert)}
+	executor := &CheckoutExecutor{BaseExecutor: BaseExecutor{inCmd: cmd}}
+
+	auth := executor.initAuth(cmd.Checkout.Credential).(*gitssh.PublicKeys)
+	assert.Nil(auth.HostKeyCallback)
+
+	cmd.Checkout.Credential.InsecureIgnoreHostKey = true
+	auth = executor.initAuth(cmd.Checkout.Credential).(*gitssh.PublicKeys)
+	assert.NotNil(auth.HostKeyCallback)
+}
+
+func TestShouldPassCredentialToGitCli(t *testing.T) {
+	assert := assert.New(t)
+
+	executor := &CheckoutExecutor{}
+	executor.option.Credential = &domain.GitCredential{Username: "flowci", Token: "token"}
+
+	env, cleanup := executor.gitCliEnv()
+	cleanup()
+	assert.Contains(env, "GIT_CONFIG_KEY_0=http.extraheader")
+	assert.Contains(env, "GIT_CONFIG_VALUE_0=Authorization: Basic Zmxvd2NpOnRva2Vu")
+
+	executor.option.Credential = &domain.GitCredential{SshKey: "key", Passphrase: "12345"}
+	env, cleanup = executor.gitCliEnv()
+
+	var keyFile string
+	for _, item := range env {
+		if strings.HasPrefix(item, "GIT_SSH_COMMAND=") {
+			keyFile = strings.Trim(item[strings.LastIndex(item, " ")+1:], "'")
+		}
+	}
+
+	content, err := ioutil.ReadFile(keyFile)
+	assert.NoError(err)
+	assert.Equal("key\n", string(content))
+	assert.Contains(env, "FLOW_GIT_PASSPHRASE=12345")
+
+	cleanup()
+	_, err = os.Stat(keyFile)
+	assert.True(os.IsNotExist(err))
+}
+
+func createTestSshKey(assert *assert.Assertions) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	return string(pem.EncodeToMemory(block))
+}
+
+func createTestRepo(assert *assert.Assertions, dir string) string {
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644)
+	assert.NoError(err)
+
+	tree, _ := repo.Worktree()
+	_, err = tree.Add("hello.txt")
+	assert.NoError(err)
+
+	hash, err := tree.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "flowci", Email: "flowci@flow.ci", When: time.Now()},
+	})
+	assert.NoError(err)
+
+	return hash.String()
+}
+
+func createCheckoutTestCmd(url string) *domain.CmdIn {
+	return &domain.CmdIn{
+		Cmd: domain.Cmd{
+			ID:     "1-1-1",
+			FlowId: "flowid",
+		},
+		Type:    domain.CmdTypeCheckout,
+		Timeout: 60,
+		Checkout: &domain.CheckoutOption{
+			Url: url,
+			Dir: "src",
+		},
+	}
+}
@@ -25,7 +25,12 @@ const (
 	dockerWorkspace = "/ws"
 	dockerPluginDir = dockerWorkspace + "/.plugins"
 	dockerEnvFile   = "/tmp/.env"
+	dockerVarsDir   = "/tmp/.flowci/vars"
+	dockerOutput    = "/tmp/.flowci_output"
 	dockerPullRetry = 3
+
+	// printed in container once tty is in raw mode without echo, then scripts can be written
+	dockerPtyReady = "__flowci_pty_ready__"
 )
 
 type (
@@ -39,10 +44,17 @@ type (
 		containerId     string
 		workDir         string
 		envFile         string
+		varFiles        map[string][]byte
+		workspaceMode   string
+		user            string
 	}
 )
 
 func (d *DockerExecutor) Init() (out error) {
+	if d.varsErr != nil {
+		return d.varsErr
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
 			out = err.(error)
@@ -52,7 +64,12 @@ func (d *DockerExecutor) Init() (out error) {
 	d.cli, out = client.NewEnvClient()
 	util.PanicIfErr(out)
 
-	d.initAgentVolume()
+	d.initWorkspaceMode()
+
+	if !d.isBindMode() {
+		d.initAgentVolume()
+	}
+
 	d.initConfig()
 
 	return
@@ -76,11 +93,18 @@ func (d *DockerExecutor) Start() (out error) {
 
 	d.pullImage()
 	d.startContainer()
-	d.copyPlugins()
+
+	// plugin dir is bind-mounted in bind mode
+	if !d.isBindMode() {
+		d.copyPlugins()
+	}
+
+	d.copyFileVars()
 
 	eid := d.runCmdInContainer()
 	exitCode := d.waitForExit(eid)
 	d.exportEnv()
+	d.exportOutput()
 
 	if d.CmdResult.IsFinishStatus() {
 		return nil
@@ -94,9 +118,39 @@ func (d *DockerExecutor) Start() (out error) {
 // private methods
 //--------------------------------------------
 
+// the workspace mode from docker option is prior to agent config, fallback to volume if workspace not set
+func (d *DockerExecutor) initWorkspaceMode() {
+	if mode := d.inCmd.Docker.WorkspaceMode; !util.IsEmptyString(mode) {
+		d.workspaceMode = mode
+	}
+
+	if util.IsEmptyString(d.workspaceMode) {
+		d.workspaceMode = domain.WorkspaceModeVolume
+	}
+
+	if d.workspaceMode != domain.WorkspaceModeVolume && d.workspaceMode != domain.WorkspaceModeBind {
+		panic(fmt.Errorf("agent: unsupported workspace mode '%s'", d.workspaceMode))
+	}
+
+	if d.isBindMode() && util.IsEmptyString(d.workspace) {
+		util.LogWarn("Workspace not set, use agent volume instead of bind mode")
+		d.workspaceMode = domain.WorkspaceModeVolume
+	}
+
+	// files created in container should be owned by agent user in bind mode
+	d.user = d.inCmd.Docker.User
+	if d.isBindMode() && util.IsEmptyString(d.user) {
+		d.user = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+}
+
+func (d *DockerExecutor) isBindMode() bool {
+	return d.workspaceMode == domain.WorkspaceModeBind
+}
+
 // agent volume that bind to /ws inside docker
 func (d *DockerExecutor) initAgentVolume() {
-	name := "agent-" + d.agentId
+	name := domain.DockerAgentVolumePrefix + d.agentId
 	ok, v := d.getVolume(name)
 
 	if !ok {
@@ -118,25 +172,28 @@ func (d *DockerExecutor) initAgentVolume() {
 func (d *DockerExecutor) initConfig() {
 	docker := d.inCmd.Docker
 
-	// set job work dir in the container = /ws/{flow id}
-	d.workDir = filepath.Join(dockerWorkspace, util.ParseString(d.FlowId()))
-	d.vars[domain.VarAgentWorkspace] = dockerWorkspace
-	d.vars[domain.VarAgentJobDir] = d.workDir
-	d.vars[domain.VarAgentPluginDir] = dockerPluginDir
+	binds := d.initWorkspace()
+	d.vars[domain.VarOutputFile] = dockerOutput
+
+	d.varFiles = d.fileVars(func(name string) string {
+		return dockerVarsDir + "/" + name
+	})
 
 	portSet, portMap, err := nat.ParsePortSpecs(docker.Ports)
 	util.PanicIfErr(err)
 
-	image := util.ParseStringWithSource(docker.Image, d.vars)
+	image, err := util.ExpandString(docker.Image, d.vars.Lookup)
+	util.PanicIfErr(err)
 
 	entrypoint := make([]string, len(docker.Entrypoint))
 	for i, item := range docker.Entrypoint {
-		entrypoint[i] = util.ParseStringWithSource(item, d.vars)
+		entrypoint[i], err = util.ExpandString(item, d.vars.Lookup)
+		util.PanicIfErr(err)
 	}
 
 	d.containerConfig = &container.Config{
 		Image:        image,
-		Env:          d.vars.ToStringArray(),
+		Env:          d.envArray(),
 		Entrypoint:   entrypoint,
 		ExposedPorts: portSet,
 		Tty:          false,
@@ -146,12 +203,14 @@ func (d *DockerExecutor) initConfig() {
 		OpenStdin:    true,
 		StdinOnce:    true,
 		WorkingDir:   d.workDir,
+		Labels:       map[string]string{domain.DockerLabelAgent: d.agentId},
+		User:         d.user,
 	}
 
 	d.hostConfig = &container.HostConfig{
 		NetworkMode:  container.NetworkMode(docker.NetworkMode),
 		PortBindings: portMap,
-		Binds:        []string{d.agentVolume.Name + ":" + dockerWorkspace},
+		Binds:        binds,
 	}
 
 	for _, v := range d.volumes {
@@ -164,6 +223,52 @@ func (d *DockerExecutor) initConfig() {
 	}
 }
 
+// init job dir and plugin dir in container, and return binds for workspace
+func (d *DockerExecutor) initWorkspace() []string {
+	if d.isBindMode() {
+		// set job work dir in the container = host job dir
+		workDir, err := jobDirOf(d.workspace, d.FlowId())
+		util.PanicIfErr(err)
+
+		d.workDir = workDir
+		d.vars[domain.VarAgentWorkspace] = d.workspace
+		d.vars[domain.VarAgentJobDir] = d.workDir
+		d.vars[domain.VarAgentPluginDir] = d.pluginDir
+
+		if d.inCmd.HasPlugin() {
+			d.vars[domain.VarPluginPath] = filepath.Join(d.pluginDir, d.inCmd.Plugin)
+		}
+
+		err = os.MkdirAll(d.workDir, os.ModePerm)
+		util.PanicIfErr(err)
+
+		err = touchDir(d.workDir)
+		util.PanicIfErr(err)
+
+		binds := []string{d.workDir + ":" + d.workDir}
+		if !util.IsEmptyString(d.pluginDir) && util.IsFileExists(d.pluginDir) {
+			binds = append(binds, d.pluginDir+":"+d.pluginDir+":ro")
+		}
+
+		return binds
+	}
+
+	// set job work dir in the container = /ws/{flow id}
+	workDir, err := jobDirOf(dockerWorkspace, d.FlowId())
+	util.PanicIfErr(err)
+
+	d.workDir = workDir
+	d.vars[domain.VarAgentWorkspace] = dockerWorkspace
+	d.vars[domain.VarAgentJobDir] = d.workDir
+	d.vars[domain.VarAgentPluginDir] = dockerPluginDir
+
+	if d.inCmd.HasPlugin() {
+		d.vars[domain.VarPluginPath] = dockerPluginDir + "/" + d.inCmd.Plugin
+	}
+
+	return []string{d.agentVolume.Name + ":" + dockerWorkspace}
+}
+
 func (d *DockerExecutor) handleErrors(err error) error {
 	if err == context.DeadlineExceeded {
 		util.LogDebug("Timeout..")
@@ -199,7 +304,7 @@ func (d *DockerExecutor) pullImage() {
 			continue
 		}
 
-		d.writeLog(reader, false)
+		d.writeLog(reader, domain.LogStream_SYSTEM, false)
 		break
 	}
 
@@ -280,22 +385,63 @@ func (d *DockerExecutor) copyPlugins() {
 	}
 }
 
+// copy content of file vars to container
+func (d *DockerExecutor) copyFileVars() {
+	if len(d.varFiles) == 0 {
+		return
+	}
+
+	// file vars should be readable by the container user
+	uid, gid := parseUserIds(d.user)
+
+	reader, err := tarArchiveFromFiles(d.varFiles, uid, gid)
+	util.PanicIfErr(err)
+
+	config := types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+	}
+
+	err = d.cli.CopyToContainer(d.context, d.containerId, "/", reader, config)
+	util.PanicIfErr(err)
+	util.LogDebug("File vars been created in container")
+}
+
 func (d *DockerExecutor) runCmdInContainer() string {
 	config := types.ExecConfig{
-		Tty:          false,
+		Tty:          d.pty != nil,
 		AttachStdin:  true,
 		AttachStderr: true,
 		AttachStdout: true,
 		Cmd:          []string{linuxBash},
 	}
 
+	// bash reads scripts from tty as file, so it's not interactive
+	if d.pty != nil {
+		config.Cmd = []string{linuxBash, "-c", fmt.Sprintf("stty raw -echo && echo %s && exec %s /dev/stdin", dockerPtyReady, linuxBash)}
+	}
+
 	exec, err := d.cli.ContainerExecCreate(d.context, d.containerId, config)
 	util.PanicIfErr(err)
 
-	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, types.ExecConfig{Tty: false})
+	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, types.ExecConfig{Tty: config.Tty})
 	util.PanicIfErr(err)
 
+	var reader io.Reader = attach.Reader
+	if d.pty != nil {
+		reader = d.waitPtyReady(exec.ID, attach.Reader)
+	}
+
 	initScriptInVolume := func(in chan string) {
+		// the output file should be empty since container could be reused
+		in <- ": > " + dockerOutput
+
+		if d.inCmd.CleanWorkspace {
+			in <- fmt.Sprintf("find %s -mindepth 1 -delete", util.ShellQuote(d.workDir))
+		}
+
+		// job dir in agent volume is cleaned by gc according to modified time
+		in <- "touch " + util.ShellQuote(d.workDir)
+
 		for _, v := range d.volumes {
 			if util.IsEmptyString(v.Script) {
 				continue
@@ -306,15 +452,32 @@ func (d *DockerExecutor) runCmdInContainer() string {
 	}
 
 	writeEnv := func(in chan string) {
-		in <- "env > " + dockerEnvFile
+		in <- "env -0 > " + dockerEnvFile
 	}
 
-	d.writeLog(attach.Reader, true)
+	d.writeLog(reader, domain.LogStream_STDOUT, true)
 	d.writeCmd(attach.Conn, initScriptInVolume, writeEnv)
 
 	return exec.ID
 }
 
+// resize tty and wait for ready marker, the scripts written before will be echoed
+func (d *DockerExecutor) waitPtyReady(execId string, reader *bufio.Reader) io.Reader {
+	size := types.ResizeOptions{Width: uint(d.pty.Cols), Height: uint(d.pty.Rows)}
+	util.LogIfError(d.cli.ContainerExecResize(d.context, execId, size))
+
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.Contains(line, dockerPtyReady) {
+			return reader
+		}
+
+		if err != nil {
+			panic(ErrorPtyNotReady)
+		}
+	}
+}
+
 func (d *DockerExecutor) exportEnv() {
 	reader, _, err := d.cli.CopyFromContainer(d.context, d.containerId, dockerEnvFile)
 	if err != nil {
@@ -322,7 +485,18 @@ func (d *DockerExecutor) exportEnv() {
 	}
 
 	defer reader.Close()
-	d.CmdResult.Output = readEnvFromReader(reader, d.inCmd.EnvFilters)
+	d.CmdResult.Output = d.filterOutput(readEnvFromTar(reader, d.inCmd.EnvFilters))
+}
+
+// merge outputs written to FLOWCI_OUTPUT file in container
+func (d *DockerExecutor) exportOutput() {
+	reader, _, err := d.cli.CopyFromContainer(d.context, d.containerId, dockerOutput)
+	if err != nil {
+		return
+	}
+
+	defer reader.Close()
+	d.mergeOutput(readOutputFromTar(reader))
 }
 
 func (d *DockerExecutor) cleanupContainer() {
@@ -441,3 +615,34 @@ func tarArchiveFromPath(path string) (io.Reader, error) {
 
 	return bufio.NewReader(&buf), nil
 }
+
+// tar archive of files which key is absolute path in container
+func tarArchiveFromFiles(files map[string][]byte, uid, gid int) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for path, content := range files {
+		header := &tar.Header{
+			Name:    strings.TrimPrefix(path, "/"),
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+			Uid:     uid,
+			Gid:     gid,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(&buf), nil
+}
@@ -5,6 +5,9 @@ import (
 	"github/flowci/flow-agent-x/config"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/util"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -66,6 +69,33 @@ func TestShouldReuseContainer(t *testing.T) {
 	assert.Equal(result.ContainerId, resultFromReuse.ContainerId)
 }
 
+func TestShouldExecInDockerWithBindWorkspace(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createDockerTestCmd()
+	cmd.Docker.WorkspaceMode = domain.WorkspaceModeBind
+	cmd.Scripts = []string{
+		"echo bind > bind.txt",
+		"export FLOW_VVV=$(id -u)",
+		"export FLOW_AAA=$PWD",
+	}
+
+	executor := newExecutor(cmd)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.LogChannel())
+	assert.NoError(executor.Start())
+
+	jobDir := filepath.Join(getTestDataDir(), "flowid")
+	defer os.Remove(filepath.Join(jobDir, "bind.txt"))
+
+	result := executor.GetResult()
+	assert.Equal(0, result.Code)
+	assert.Equal(strconv.Itoa(os.Getuid()), result.Output["FLOW_VVV"])
+	assert.Equal(jobDir, result.Output["FLOW_AAA"])
+	assert.True(util.IsFileExists(filepath.Join(jobDir, "bind.txt")))
+}
+
 func createDockerTestCmd() *domain.CmdIn {
 	return &domain.CmdIn{
 		Cmd: domain.Cmd{
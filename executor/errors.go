@@ -0,0 +1,18 @@
+package executor
+
+import "errors"
+
+var (
+	ErrorFlowIdMissing = errors.New("agent: flow id is missing")
+	ErrorJobDirOutside = errors.New("agent: job dir should be sub dir of workspace")
+
+	ErrorCheckoutOptionMissing = errors.New("agent: checkout option is missing")
+	ErrorCheckoutUrlMissing    = errors.New("agent: git url is missing for checkout")
+	ErrorCheckoutDirOutside    = errors.New("agent: checkout dir should be inside job dir")
+
+	ErrorBuildOptionMissing     = errors.New("agent: build option is missing")
+	ErrorBuildContextOutside    = errors.New("agent: build context should be inside job dir")
+	ErrorBuildTagsMissingToPush = errors.New("agent: tags are required to push image")
+
+	ErrorPtyNotReady = errors.New("agent: unable to init pseudo terminal in container")
+)
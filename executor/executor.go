@@ -1,11 +1,17 @@
 package executor
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/util"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,6 +24,19 @@ const (
 	defaultLogChannelBufferSize = 10000
 	defaultLogWaitingDuration   = 5 * time.Second
 	defaultReaderBufferSize     = 8 * 1024 // 8k
+
+	defaultPtyCols = 80
+	defaultPtyRows = 24
+	ptyTerm        = "xterm-256color"
+
+	hookPreCmd    = "pre_cmd"
+	hookPostCmd   = "post_cmd"
+	hookOnFailure = "on_failure"
+	hookExitFunc  = "__flowci_on_exit"
+)
+
+var (
+	secretMask = []byte("******")
 )
 
 type TypeOfExecutor int
@@ -27,6 +46,10 @@ type Executor interface {
 
 	CmdId() string
 
+	JobId() string
+
+	FlowId() string
+
 	BashChannel() chan<- string
 
 	LogChannel() <-chan *domain.LogItem
@@ -49,7 +72,18 @@ type BaseExecutor struct {
 	bashChannel chan string          // bash script comes from
 	logChannel  chan *domain.LogItem // output log
 	CmdResult   *domain.ExecutedCmd
-	stdOutWg    sync.WaitGroup // init on subclasses
+	stdOutWg    *sync.WaitGroup // init on subclasses
+	varsErr     error           // error from resolving vars, returned on Init
+	secrets     [][]byte        // value of secret vars which will be masked in log
+	hooks       Hooks
+	pty         *domain.PtyOption // run in pseudo terminal if not nil
+}
+
+// Hooks agent level scripts which run in the same shell of cmd
+type Hooks struct {
+	PreCmd    string // run before cmd scripts
+	PostCmd   string // run after cmd scripts whatever the result
+	OnFailure string // run after cmd scripts if exit code is not 0
 }
 
 type Options struct {
@@ -60,6 +94,10 @@ type Options struct {
 	Cmd       *domain.CmdIn
 	Vars      domain.Variables
 	Volumes   []*domain.DockerVolume
+	Hooks     Hooks
+
+	// workspace mode of docker step, volume or bind
+	WorkspaceMode string
 }
 
 func NewExecutor(options Options) Executor {
@@ -70,7 +108,21 @@ func NewExecutor(options Options) Executor {
 	cmd := options.Cmd
 
 	vars := domain.ConnectVars(options.Vars, cmd.Inputs)
-	vars.Resolve()
+	vars[domain.VarCmdId] = cmd.ID
+	vars[domain.VarCmdFlowId] = cmd.FlowId
+	vars[domain.VarCmdJobId] = cmd.JobId
+	vars[domain.VarCmdNodePath] = cmd.NodePath
+	vars[domain.VarCmdBuildNum] = strconv.Itoa(cmd.BuildNumber)
+
+	varsErr := vars.Resolve(cmd.Meta)
+	if varsErr == nil {
+		varsErr = cmd.Meta.Normalize(vars)
+	}
+
+	// plugin is stored in dir 'name' or 'name@version'
+	if cmd.HasPlugin() {
+		vars[domain.VarPluginPath] = filepath.Join(options.PluginDir, cmd.Plugin)
+	}
 
 	base := BaseExecutor{
 		agentId:     options.AgentId,
@@ -81,16 +133,34 @@ func NewExecutor(options Options) Executor {
 		inCmd:       cmd,
 		vars:        vars,
 		CmdResult:   domain.NewExecutedCmd(cmd),
+		stdOutWg:    new(sync.WaitGroup),
+		varsErr:     varsErr,
+		secrets:     secretsOf(vars, cmd.Meta),
+		hooks:       options.Hooks,
+		pty:         ptyOptionOf(cmd),
 	}
 
 	ctx, cancel := context.WithTimeout(options.Parent, time.Duration(cmd.Timeout)*time.Second)
 	base.context = ctx
 	base.cancelFunc = cancel
 
+	if cmd.Type == domain.CmdTypeCheckout {
+		return &CheckoutExecutor{
+			BaseExecutor: base,
+		}
+	}
+
+	if cmd.Type == domain.CmdTypeBuild {
+		return &BuildExecutor{
+			BaseExecutor: base,
+		}
+	}
+
 	if cmd.HasDockerOption() {
 		return &DockerExecutor{
-			BaseExecutor: base,
-			volumes:      options.Volumes,
+			BaseExecutor:  base,
+			volumes:       options.Volumes,
+			workspaceMode: options.WorkspaceMode,
 		}
 	}
 
@@ -135,6 +205,57 @@ func (b *BaseExecutor) Kill() {
 //	private
 //====================================================================
 
+// the env of process which excludes the vars not exported
+func (b *BaseExecutor) envArray() []string {
+	return b.vars.ToEnvArray(b.inCmd.Meta)
+}
+
+// replace value of file vars to file path, and return content by file path
+func (b *BaseExecutor) fileVars(pathOf func(name string) string) map[string][]byte {
+	files := make(map[string][]byte)
+
+	for _, name := range b.inCmd.Meta.Names(domain.VarTypeFile) {
+		content, ok := b.vars[name]
+		if !ok {
+			continue
+		}
+
+		path := pathOf(name)
+		files[path] = []byte(content)
+		b.vars[name] = path
+	}
+
+	return files
+}
+
+// merge outputs from FLOWCI_OUTPUT file, which will override exported env
+func (b *BaseExecutor) mergeOutput(outputs domain.Variables) {
+	if b.CmdResult.Output == nil {
+		b.CmdResult.Output = domain.NewVariables()
+	}
+
+	for key, val := range outputs {
+		b.CmdResult.Output[key] = val
+	}
+
+	b.filterOutput(b.CmdResult.Output)
+}
+
+// secret vars should not be exported to output
+func (b *BaseExecutor) filterOutput(output domain.Variables) domain.Variables {
+	for _, name := range b.inCmd.Meta.Names(domain.VarTypeSecret) {
+		delete(output, name)
+	}
+	return output
+}
+
+func (b *BaseExecutor) maskSecrets(content []byte) []byte {
+	for _, secret := range b.secrets {
+		content = bytes.Replace(content, secret, secretMask, -1)
+	}
+	return content
+}
+
 func (b *BaseExecutor) writeCmd(stdin io.Writer, before, after func(chan string)) {
 	consumer := func() {
 		for {
@@ -155,11 +276,14 @@ func (b *BaseExecutor) writeCmd(stdin io.Writer, before, after func(chan string)
 	go consumer()
 
 	b.bashChannel <- "set -e"
+	b.writeExitHooks()
 
 	if before != nil {
 		before(b.bashChannel)
 	}
 
+	b.writeHook(hookPreCmd, b.hooks.PreCmd)
+
 	for _, script := range b.inCmd.Scripts {
 		b.bashChannel <- script
 	}
@@ -171,16 +295,54 @@ func (b *BaseExecutor) writeCmd(stdin io.Writer, before, after func(chan string)
 	b.bashChannel <- "exit"
 }
 
+// write hook script with marked log in the same shell
+func (b *BaseExecutor) writeHook(name, script string) {
+	if util.IsEmptyString(strings.TrimSpace(script)) {
+		return
+	}
+
+	b.bashChannel <- hookScript(name, script)
+}
+
+// post_cmd and on_failure hooks are run on shell exit by trap, since 'set -e' exit shell on error
+func (b *BaseExecutor) writeExitHooks() {
+	if util.IsEmptyString(strings.TrimSpace(b.hooks.PostCmd)) && util.IsEmptyString(strings.TrimSpace(b.hooks.OnFailure)) {
+		return
+	}
+
+	var script strings.Builder
+	script.WriteString(hookExitFunc + "() {\n")
+	script.WriteString(fmt.Sprintf("%s=$?\n", domain.VarCmdExitCode))
+	script.WriteString("set +e\n")
+	script.WriteString(fmt.Sprintf("trap - EXIT\nexport %s\n", domain.VarCmdExitCode))
+
+	if !util.IsEmptyString(strings.TrimSpace(b.hooks.OnFailure)) {
+		script.WriteString(fmt.Sprintf("if [ $%s -ne 0 ]; then\n", domain.VarCmdExitCode))
+		script.WriteString(hookScript(hookOnFailure, b.hooks.OnFailure))
+		script.WriteString("fi\n")
+	}
+
+	if !util.IsEmptyString(strings.TrimSpace(b.hooks.PostCmd)) {
+		script.WriteString(hookScript(hookPostCmd, b.hooks.PostCmd))
+	}
+
+	script.WriteString(fmt.Sprintf("exit $%s\n}\n", domain.VarCmdExitCode))
+	script.WriteString("trap " + hookExitFunc + " EXIT")
+
+	b.bashChannel <- script.String()
+}
+
 func (b *BaseExecutor) closeChannels() {
 	if len(b.LogChannel()) > 0 {
-		util.Wait(&b.stdOutWg, defaultLogWaitingDuration)
+		util.Wait(b.stdOutWg, defaultLogWaitingDuration)
 	}
 
 	close(b.bashChannel)
 	close(b.logChannel)
 }
 
-func (b *BaseExecutor) writeLog(reader io.Reader, doneOnWaitGroup bool) {
+// read log from stdout or stderr, the stream is from header if it's multiplexed docker stream
+func (b *BaseExecutor) writeLog(reader io.Reader, stream domain.LogStream, doneOnWaitGroup bool) {
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
@@ -196,34 +358,126 @@ func (b *BaseExecutor) writeLog(reader io.Reader, doneOnWaitGroup bool) {
 
 		buffer := make([]byte, defaultReaderBufferSize)
 
+		// masker of each stream, since docker frames of stdout and stderr are from the same reader
+		maskers := make(map[domain.LogStream]*secretMasker)
+
+		send := func(stream domain.LogStream, content []byte) {
+			if len(content) == 0 {
+				return
+			}
+
+			b.logChannel <- &domain.LogItem{
+				CmdId:   b.CmdId(),
+				Stream:  stream,
+				Content: content,
+			}
+		}
+
+		flush := func() {
+			for stream, masker := range maskers {
+				send(stream, masker.flush())
+			}
+		}
+
 		for {
 			select {
 			case <-b.context.Done():
+				flush()
 				return
 			default:
 				n, err := reader.Read(buffer)
 				if err != nil {
+					flush()
 					return
 				}
 
-				b.logChannel <- &domain.LogItem{
-					CmdId:   b.CmdId(),
-					Content: removeDockerHeader(buffer[0:n]),
+				content := buffer[0:n]
+				var streamOfHeader *domain.LogStream
+
+				// the tty output has no docker header
+				if b.pty == nil {
+					content, streamOfHeader = removeDockerHeader(content)
+				}
+
+				// copy content since the buffer is reused on next read
+				content = append([]byte(nil), content...)
+
+				if streamOfHeader != nil {
+					stream = *streamOfHeader
+				}
+
+				masker, ok := maskers[stream]
+				if !ok {
+					masker = &secretMasker{secrets: b.secrets}
+					maskers[stream] = masker
 				}
 
+				send(stream, masker.mask(content))
 				atomic.AddInt64(&b.CmdResult.LogSize, int64(n))
 			}
 		}
 	}()
 }
 
+func (b *BaseExecutor) writeLogItem(content []byte) {
+	b.logChannel <- &domain.LogItem{
+		CmdId:   b.CmdId(),
+		Stream:  domain.LogStream_STDOUT,
+		Content: b.maskSecrets(content),
+	}
+
+	atomic.AddInt64(&b.CmdResult.LogSize, int64(len(content)))
+}
+
+// log written by agent
 func (b *BaseExecutor) writeSingleLog(msg string) {
 	b.logChannel <- &domain.LogItem{
 		CmdId:   b.CmdId(),
+		Stream:  domain.LogStream_SYSTEM,
 		Content: []byte(msg),
 	}
 }
 
+// job dir is workspace/{flow id}, or temp dir if workspace not set
+func (b *BaseExecutor) initJobDir() (string, error) {
+	if util.IsEmptyString(b.workspace) {
+		dir, err := ioutil.TempDir("", "agent_")
+		b.vars[domain.VarAgentJobDir] = dir
+		return dir, err
+	}
+
+	dir, err := jobDirOf(b.workspace, b.FlowId())
+	if err != nil {
+		return dir, err
+	}
+
+	b.vars[domain.VarAgentJobDir] = dir
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return dir, err
+	}
+
+	return dir, touchDir(dir)
+}
+
+// to status by error of the executor which runs within agent process, ex: checkout and build
+func (b *BaseExecutor) toStatusOfError(err error) error {
+	if err == context.DeadlineExceeded {
+		util.LogDebug("Timeout..")
+		b.toTimeOutStatus()
+		return nil
+	}
+
+	if err == context.Canceled {
+		util.LogDebug("Cancel..")
+		b.toKilledStatus()
+		return nil
+	}
+
+	b.writeSingleLog(err.Error() + "\n")
+	return b.toErrorStatus(err)
+}
+
 func (b *BaseExecutor) toStartStatus(pid int) {
 	b.CmdResult.Status = domain.CmdStatusRunning
 	b.CmdResult.ProcessId = pid
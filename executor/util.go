@@ -1,21 +1,49 @@
 package executor
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"fmt"
 	"github/flowci/flow-agent-x/domain"
 	"github/flowci/flow-agent-x/util"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const (
 	dockerHeaderSize = 8
 	dockerHeaderPrefixSize = 4 // [STREAM_TYPE, 0, 0 ,0, ....]
+
+	envDelimiter      = '\x00'
+	envBashFuncPrefix = "BASH_FUNC_"
+	envGlobChars      = "*?["
+
+	outputHeredoc     = "<<"
+	outputMaxLineSize = 1024 * 1024 // 1m
 )
 
+type envMatcher struct {
+	exact  []string
+	prefix []string
+	glob   []string
+	regex  []*regexp.Regexp
+}
+
+// mask secrets in chunks of stream, the tail which could be the beginning of a secret
+// is held until next chunk, so the secret split across reads is masked as well
+type secretMasker struct {
+	secrets [][]byte
+	pending []byte
+}
+
 var (
 	dockerStdInHeaderPrefix  = []byte{1, 0, 0, 0}
 	dockerStdErrHeaderPrefix = []byte{2, 0, 0, 0}
@@ -26,34 +54,182 @@ func getExitCode(cmd *exec.Cmd) int {
 	return ws.ExitStatus()
 }
 
+// read env from 'env -0' output, which is NUL delimited and value could be multiple lines
 func readEnvFromReader(r io.Reader, filters []string) domain.Variables {
 	reader := bufio.NewReader(r)
 	output := domain.NewVariables()
+	matcher := newEnvMatcher(filters)
 
 	for {
-		line, err := reader.ReadString(util.UnixLineBreak)
+		entry, err := reader.ReadString(envDelimiter)
+		entry = strings.TrimSuffix(entry, string(envDelimiter))
+
+		if ok, key, val := getEnvKeyAndVal(entry); ok && matcher.match(key) {
+			output[key] = val
+		}
+
 		if err != nil {
 			return output
 		}
+	}
+}
+
+// read env file from tar archive which is returned from docker
+func readEnvFromTar(r io.Reader, filters []string) domain.Variables {
+	reader := tar.NewReader(r)
 
-		line = strings.TrimSpace(line)
-		if ok, key, val := getEnvKeyAndVal(line); ok {
-			if matchEnvFilter(key, filters) {
-				output[key] = val
+	if _, err := reader.Next(); err != nil {
+		return domain.NewVariables()
+	}
+
+	return readEnvFromReader(reader, filters)
+}
+
+// read step outputs from FLOWCI_OUTPUT file, which support 'key=value' or heredoc block like:
+//
+//	key<<EOF
+//	multiple lines
+//	EOF
+func readOutputFromReader(r io.Reader) domain.Variables {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, defaultReaderBufferSize), outputMaxLineSize)
+	output := make(domain.Variables)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if util.IsEmptyString(strings.TrimSpace(line)) {
+			continue
+		}
+
+		index := strings.Index(line, "=")
+		heredoc := strings.Index(line, outputHeredoc)
+
+		// heredoc block if '<<' comes before '='
+		if heredoc > 0 && (index == -1 || heredoc < index) {
+			key := line[:heredoc]
+			delimiter := line[heredoc+len(outputHeredoc):]
+
+			var lines []string
+			closed := false
+
+			for scanner.Scan() {
+				if scanner.Text() == delimiter {
+					closed = true
+					break
+				}
+				lines = append(lines, scanner.Text())
 			}
+
+			if !closed {
+				util.LogWarn("Output '%s' missing delimiter '%s'", key, delimiter)
+				return output
+			}
+
+			output[key] = strings.Join(lines, util.UnixLineBreakStr)
+			continue
 		}
+
+		if index <= 0 {
+			util.LogWarn("Invalid output line: %s", line)
+			continue
+		}
+
+		output[line[:index]] = line[index+1:]
 	}
+
+	return output
 }
 
-func matchEnvFilter(env string, filters []string) bool {
+// read output file from tar archive which is returned from docker
+func readOutputFromTar(r io.Reader) domain.Variables {
+	reader := tar.NewReader(r)
+
+	if _, err := reader.Next(); err != nil {
+		return make(domain.Variables)
+	}
+
+	return readOutputFromReader(reader)
+}
+
+// parse numeric 'uid:gid' of docker user, return 0 if it's user name
+func parseUserIds(user string) (uid, gid int) {
+	parts := strings.SplitN(user, ":", 2)
+	uid, _ = strconv.Atoi(parts[0])
+
+	if len(parts) == 2 {
+		gid, _ = strconv.Atoi(parts[1])
+	}
+
+	return
+}
+
+// hook script with begin and end mark in log
+func hookScript(name, script string) string {
+	return fmt.Sprintf("echo '[flow.ci] >>> hook %s'\n%s\necho '[flow.ci] <<< hook %s'\n", name, strings.TrimRight(script, util.UnixLineBreakStr), name)
+}
+
+func newEnvMatcher(filters []string) *envMatcher {
+	m := &envMatcher{}
+
 	for _, filter := range filters {
-		if strings.HasPrefix(env, filter) {
+		switch {
+		case util.IsEmptyString(filter):
+			continue
+
+		case strings.HasPrefix(filter, domain.EnvFilterExact):
+			m.exact = append(m.exact, strings.TrimPrefix(filter, domain.EnvFilterExact))
+
+		case len(filter) > 2 && strings.HasPrefix(filter, domain.EnvFilterRegex) && strings.HasSuffix(filter, domain.EnvFilterRegex):
+			r, err := regexp.Compile(filter[1 : len(filter)-1])
+			if err != nil {
+				util.LogWarn("Invalid env filter '%s': %v", filter, err)
+				continue
+			}
+			m.regex = append(m.regex, r)
+
+		case strings.ContainsAny(filter, envGlobChars):
+			m.glob = append(m.glob, filter)
+
+		default:
+			m.prefix = append(m.prefix, filter)
+		}
+	}
+
+	return m
+}
+
+func (m *envMatcher) match(env string) bool {
+	for _, name := range m.exact {
+		if env == name {
 			return true
 		}
 	}
+
+	for _, prefix := range m.prefix {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+
+	for _, pattern := range m.glob {
+		if ok, _ := filepath.Match(pattern, env); ok {
+			return true
+		}
+	}
+
+	for _, r := range m.regex {
+		if r.MatchString(env) {
+			return true
+		}
+	}
+
 	return false
 }
 
+func matchEnvFilter(env string, filters []string) bool {
+	return newEnvMatcher(filters).match(env)
+}
+
 func appendNewLine(script string) string {
 	if !strings.HasSuffix(script, util.UnixLineBreakStr) {
 		script += util.UnixLineBreakStr
@@ -63,7 +239,13 @@ func appendNewLine(script string) string {
 
 func getEnvKeyAndVal(line string) (ok bool, key, val string) {
 	index := strings.IndexAny(line, "=")
-	if index == -1 {
+	if index <= 0 {
+		ok = false
+		return
+	}
+
+	// exported bash function, ex: BASH_FUNC_name%%=() { ... }
+	if strings.HasPrefix(line, envBashFuncPrefix) {
 		ok = false
 		return
 	}
@@ -74,18 +256,121 @@ func getEnvKeyAndVal(line string) (ok bool, key, val string) {
 	return
 }
 
-func removeDockerHeader (in []byte) []byte {
+// remove header of multiplexed docker stream, the stream is nil if no header
+func removeDockerHeader(in []byte) ([]byte, *domain.LogStream) {
 	if len(in) < dockerHeaderSize {
-		return in
+		return in, nil
 	}
 
 	if bytes.Compare(in[:dockerHeaderPrefixSize], dockerStdInHeaderPrefix) == 0 {
-		return in[dockerHeaderSize:]
+		stream := domain.LogStream_STDOUT
+		return in[dockerHeaderSize:], &stream
 	}
 
 	if bytes.Compare(in[:dockerHeaderPrefixSize], dockerStdErrHeaderPrefix) == 0 {
-		return in[dockerHeaderSize:]
+		stream := domain.LogStream_STDERR
+		return in[dockerHeaderSize:], &stream
+	}
+
+	return in, nil
+}
+
+// pty option with default size, nil if pty not enabled
+func ptyOptionOf(cmd *domain.CmdIn) *domain.PtyOption {
+	if !cmd.HasPtyOption() {
+		return nil
+	}
+
+	option := *cmd.Pty
+	if option.Cols == 0 {
+		option.Cols = defaultPtyCols
+	}
+
+	if option.Rows == 0 {
+		option.Rows = defaultPtyRows
+	}
+
+	return &option
+}
+
+func (m *secretMasker) mask(content []byte) []byte {
+	if len(m.secrets) == 0 {
+		return content
+	}
+
+	data := append(m.pending, content...)
+	for _, secret := range m.secrets {
+		data = bytes.Replace(data, secret, secretMask, -1)
+	}
+
+	held := m.heldSize(data)
+	m.pending = append([]byte(nil), data[len(data)-held:]...)
+	return data[:len(data)-held]
+}
+
+// the held content at the end of stream
+func (m *secretMasker) flush() []byte {
+	out := m.pending
+	m.pending = nil
+	return out
+}
+
+// size of the longest tail which is prefix of a secret, it's less than max length of secrets
+func (m *secretMasker) heldSize(data []byte) int {
+	held := 0
+	for _, secret := range m.secrets {
+		n := len(secret) - 1
+		if n > len(data) {
+			n = len(data)
+		}
+
+		for ; n > held; n-- {
+			if bytes.HasPrefix(secret, data[len(data)-n:]) {
+				held = n
+				break
+			}
+		}
+	}
+	return held
+}
+
+func secretsOf(vars domain.Variables, metas domain.VarMetas) [][]byte {
+	var secrets [][]byte
+	for _, name := range metas.Names(domain.VarTypeSecret) {
+		if val := vars[name]; !util.IsEmptyString(val) {
+			secrets = append(secrets, []byte(val))
+		}
+	}
+	return secrets
+}
+
+// check path is inside the dir after cleaned, both should be absolute or relative
+func isInsideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// job dir of flow which should be the sub dir of workspace, since it could be removed by clean workspace
+func jobDirOf(workspace, flowId string) (string, error) {
+	flowId = util.ParseString(flowId)
+	if util.IsEmptyString(flowId) {
+		return "", ErrorFlowIdMissing
 	}
 
-	return in
+	dir := filepath.Join(workspace, flowId)
+	if filepath.Clean(workspace) == dir || !isInsideDir(workspace, dir) {
+		return "", ErrorJobDirOutside
+	}
+
+	return dir, nil
+}
+
+// the modified time of job dir is used by workspace gc
+func touchDir(dir string) error {
+	now := time.Now()
+	return os.Chtimes(dir, now, now)
 }
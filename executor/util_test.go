@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	"github/flowci/flow-agent-x/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldReadMultiLineEnvAndSkipFunctions(t *testing.T) {
+	assert := assert.New(t)
+
+	env := "FLOW_KEY=-----BEGIN KEY-----\nabc\n-----END KEY-----\x00" +
+		"BASH_FUNC_hello%%=() {  echo hello\n}\x00" +
+		"FLOW_JSON={\"a\": 1}\n\x00" +
+		"OTHER=value\x00"
+
+	output := readEnvFromReader(strings.NewReader(env), []string{"FLOW_", "BASH_"})
+	assert.Equal("-----BEGIN KEY-----\nabc\n-----END KEY-----", output["FLOW_KEY"])
+	assert.Equal("{\"a\": 1}\n", output["FLOW_JSON"])
+	assert.NotContains(output, "OTHER")
+	assert.NotContains(output, "BASH_FUNC_hello%%")
+}
+
+func TestShouldMatchEnvFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(matchEnvFilter("FLOW_VAR", []string{"FLOW_"}))
+	assert.True(matchEnvFilter("FLOW_VAR", []string{"=FLOW_VAR"}))
+	assert.False(matchEnvFilter("FLOW_VAR_2", []string{"=FLOW_VAR"}))
+	assert.True(matchEnvFilter("MY_BUILD_ID", []string{"MY_*_ID"}))
+	assert.False(matchEnvFilter("MY_BUILD_NAME", []string{"MY_*_ID"}))
+	assert.True(matchEnvFilter("APP_VERSION", []string{"/^(APP|LIB)_VERSION$/"}))
+	assert.False(matchEnvFilter("APP_VERSION_2", []string{"/^(APP|LIB)_VERSION$/", "/[invalid/"}))
+}
+
+func TestShouldReadOutputWithHeredoc(t *testing.T) {
+	assert := assert.New(t)
+
+	content := "VERSION=1.0.0\n" +
+		"\n" +
+		"URL=http://host?a=b\n" +
+		"NOTES<<EOF\nline 1\nline=2\nEOF\n" +
+		"invalid line\n"
+
+	output := readOutputFromReader(strings.NewReader(content))
+	assert.Equal(3, len(output))
+	assert.Equal("1.0.0", output["VERSION"])
+	assert.Equal("http://host?a=b", output["URL"])
+	assert.Equal("line 1\nline=2", output["NOTES"])
+}
+
+func TestShouldParseDockerUserIds(t *testing.T) {
+	assert := assert.New(t)
+
+	uid, gid := parseUserIds("1000:1001")
+	assert.Equal(1000, uid)
+	assert.Equal(1001, gid)
+
+	uid, gid = parseUserIds("root")
+	assert.Equal(0, uid)
+	assert.Equal(0, gid)
+}
+
+func TestShouldApplyDefaultPtySize(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(ptyOptionOf(&domain.CmdIn{}))
+
+	option := ptyOptionOf(&domain.CmdIn{Pty: &domain.PtyOption{Cols: 200}})
+	assert.Equal(domain.PtyOption{Cols: 200, Rows: defaultPtyRows}, *option)
+
+	option = ptyOptionOf(&domain.CmdIn{Pty: &domain.PtyOption{}})
+	assert.Equal(domain.PtyOption{Cols: defaultPtyCols, Rows: defaultPtyRows}, *option)
+}
+
+func TestShouldMaskSecretSplitAcrossChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	masker := &secretMasker{secrets: [][]byte{[]byte("password")}}
+
+	var out []byte
+	for _, chunk := range []string{"login with pass", "wo", "rd ok, pa", "ss"} {
+		out = append(out, masker.mask([]byte(chunk))...)
+	}
+
+	assert.Equal("login with ****** ok, ", string(out))
+	assert.Equal("pass", string(masker.flush()))
+	assert.Nil(masker.flush())
+
+	// content is not held without secrets
+	assert.Equal("pass", string((&secretMasker{}).mask([]byte("pass"))))
+}
+
+func TestShouldGetJobDirInsideWorkspace(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := jobDirOf("/ws", "flow-1")
+	assert.NoError(err)
+	assert.Equal("/ws/flow-1", dir)
+
+	_, err = jobDirOf("/ws", "")
+	assert.Equal(ErrorFlowIdMissing, err)
+
+	_, err = jobDirOf("/ws", ".")
+	assert.Equal(ErrorJobDirOutside, err)
+
+	_, err = jobDirOf("/ws", "../flow-1")
+	assert.Equal(ErrorJobDirOutside, err)
+}
@@ -2,6 +2,10 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -16,6 +20,11 @@ import (
 	"github/flowci/flow-agent-x/util"
 )
 
+const (
+	consumerMaxBackoff = 30 * time.Second
+	consumerHealthWait = 5 * time.Second
+)
+
 var (
 	singleton *CmdService
 	once      sync.Once
@@ -26,9 +35,10 @@ type (
 
 	// CmdService receive and execute cmd
 	CmdService struct {
-		executor executor.Executor
-		mux      sync.Mutex
-		session  CmdInteractSession
+		executor    executor.Executor
+		mux         sync.Mutex
+		session     CmdInteractSession
+		consumerTag string
 	}
 )
 
@@ -37,6 +47,7 @@ func GetCmdService() *CmdService {
 	once.Do(func() {
 		singleton = new(CmdService)
 		singleton.session = make(CmdInteractSession, 10)
+		singleton.consumerTag = "agent-" + uuid.New().String()
 		singleton.start()
 	})
 	return singleton
@@ -47,11 +58,26 @@ func (s *CmdService) IsRunning() bool {
 	return s.executor != nil
 }
 
+// RunningFlowId flow id of running cmd, empty if not running
+func (s *CmdService) RunningFlowId() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.IsRunning() {
+		return s.executor.FlowId()
+	}
+	return util.EmptyStr
+}
+
 // Execute execute cmd according to the type
 func (s *CmdService) Execute(in *domain.CmdIn) error {
 	switch in.Type {
 	case domain.CmdTypeShell:
 		return s.execShell(in)
+	case domain.CmdTypeCheckout:
+		return s.execShell(in)
+	case domain.CmdTypeBuild:
+		return s.execShell(in)
 	case domain.CmdTypeKill:
 		return s.execKill(in)
 	case domain.CmdTypeClose:
@@ -69,48 +95,107 @@ func (s *CmdService) start() {
 		return
 	}
 
-	channel := config.Queue.Channel
-	queue := config.Queue.JobQueue
+	go func() {
+		defer util.LogDebug("[Exit]: Rabbit mq consumer")
+
+		for attempt := 0; ; attempt++ {
+			// not consume jobs until host checks passed
+			if !config.IsHealthy() {
+				select {
+				case <-config.AppCtx.Done():
+					return
+				case <-time.After(consumerHealthWait):
+					continue
+				}
+			}
+
+			msgs, err := s.consume()
+			if err == nil {
+				attempt = 0
+				s.handleMessages(msgs)
+			} else {
+				util.LogWarn("Unable to consume job queue: %v", err)
+			}
+
+			// the channel been closed by reconnecting, consume from current queue again
+			select {
+			case <-config.AppCtx.Done():
+				return
+			case <-time.After(util.Backoff(attempt, time.Second, consumerMaxBackoff)):
+			}
+		}
+	}()
+}
+
+func (s *CmdService) consume() (<-chan amqp.Delivery, error) {
+	config := config.GetInstance()
+
+	queue := config.GetQueue()
+	if queue == nil {
+		return nil, ErrorQueueNotConnected
+	}
+
+	return queue.Consume(s.consumerTag)
+}
 
-	msgs, err := channel.Consume(queue.Name, "", true, false, false, false, nil)
-	if util.HasError(err) {
-		util.LogIfError(err)
+// cancel the consumer, the delivery channel will be closed
+func (s *CmdService) pauseConsume() {
+	config := config.GetInstance()
+
+	queue := config.GetQueue()
+	if queue == nil {
 		return
 	}
 
-	go func() {
-		defer util.LogDebug("[Exit]: Rabbit mq consumer")
+	err := queue.CancelConsume()
+	if !util.LogIfError(err) {
+		util.LogInfo("Stop consuming jobs since agent is unhealthy")
+	}
+}
 
-		for {
-			select {
-			case d, ok := <-msgs:
-				if !ok {
-					break
-				}
+// handle messages until the delivery channel closed
+func (s *CmdService) handleMessages(msgs <-chan amqp.Delivery) {
+	for {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return
+			}
 
-				util.LogDebug("Received a message: %s", d.Body)
+			util.LogDebug("Received a message: %s", d.Body)
 
-				var cmdIn domain.CmdIn
-				err := json.Unmarshal(d.Body, &cmdIn)
+			var cmdIn domain.CmdIn
+			err := json.Unmarshal(d.Body, &cmdIn)
 
-				if util.LogIfError(err) {
-					continue
-				}
+			// invalid message will not be redelivered
+			if util.LogIfError(err) {
+				util.LogIfError(d.Reject(false))
+				continue
+			}
 
-				err = s.Execute(&cmdIn)
-				if err != nil {
-					util.LogDebug(err.Error())
-				}
+			// ack after cmd accepted or the failure result saved to outbox, redelivered if agent crashed before
+			err = s.Execute(&cmdIn)
 
-			case <-time.After(time.Second * 10):
-				util.LogDebug("...")
+			// the job queue is per agent, report the busy failure to server instead of requeue,
+			// so the kill or close cmd behind it still can be delivered
+			if err == ErrorCmdIsRunning {
+				util.LogWarn("Cmd '%s' rejected since cmd is running", cmdIn.ID)
+				s.failureBeforeExecute(&cmdIn, err)
+			} else if err != nil {
+				util.LogDebug(err.Error())
 			}
+
+			util.LogIfError(d.Ack(false))
+
+		case <-time.After(time.Second * 10):
+			util.LogDebug("...")
 		}
-	}()
+	}
 }
 
 func (s *CmdService) release() {
 	s.executor = nil
+	config.GetInstance().SetBusy(false)
 	util.LogDebug("[Exit]: cmd been executed and service is available !")
 }
 
@@ -130,15 +215,18 @@ func (s *CmdService) execShell(in *domain.CmdIn) (out error) {
 		return ErrorCmdIsRunning
 	}
 
-	err := verifyAndInitShellCmd(in)
-	util.PanicIfErr(err)
-
+	// plugin manifest could provide script and inputs
 	if in.HasPlugin() {
-		plugins := util.NewPlugins(config.PluginDir, config.Server)
-		err := plugins.Load(in.Plugin)
+		dir, err := loadPlugin(in.Plugin)
+		util.PanicIfErr(err)
+
+		err = applyPluginManifest(in, dir)
 		util.PanicIfErr(err)
 	}
 
+	err := verifyAndInitShellCmd(in)
+	util.PanicIfErr(err)
+
 	s.executor = executor.NewExecutor(executor.Options{
 		AgentId:   config.Token,
 		Parent:    config.AppCtx,
@@ -147,12 +235,21 @@ func (s *CmdService) execShell(in *domain.CmdIn) (out error) {
 		Cmd:       in,
 		Vars:      s.initEnv(),
 		Volumes:   config.Volumes,
+		Hooks:     s.loadHooks(),
+
+		WorkspaceMode: config.WorkspaceMode,
 	})
 
+	// zk node is re-registered with busy status if settings reloaded while running
+	config.SetBusy(true)
+
 	err = s.executor.Init()
-	util.PanicIfErr(err)
+	if err != nil {
+		s.release()
+		panic(err)
+	}
 
-	go logConsumer(s.executor, config.LoggingDir)
+	go logConsumer(s.executor, config.LoggingDir, logLimitOf(config, in))
 
 	go func() {
 		defer s.release()
@@ -181,6 +278,31 @@ func (s *CmdService) initEnv() domain.Variables {
 	return vars
 }
 
+// load hook scripts from file every time, so the hooks can be changed without restart
+func (s *CmdService) loadHooks() executor.Hooks {
+	config := config.GetInstance()
+
+	read := func(path string) string {
+		if util.IsEmptyString(path) {
+			return util.EmptyStr
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			util.LogWarn("Unable to read hook script '%s': %v", path, err)
+			return util.EmptyStr
+		}
+
+		return string(content)
+	}
+
+	return executor.Hooks{
+		PreCmd:    read(config.PreCmdHook),
+		PostCmd:   read(config.PostCmdHook),
+		OnFailure: read(config.OnFailureHook),
+	}
+}
+
 func (s *CmdService) execKill(in *domain.CmdIn) error {
 	if s.IsRunning() {
 		s.executor.Kill()
@@ -213,7 +335,15 @@ func (s *CmdService) failureBeforeExecute(in *domain.CmdIn, err error) {
 }
 
 func verifyAndInitShellCmd(in *domain.CmdIn) error {
-	if !in.HasScripts() {
+	if in.Type == domain.CmdTypeCheckout {
+		if !in.HasCheckoutOption() {
+			return ErrorCmdMissingCheckoutOption
+		}
+	} else if in.Type == domain.CmdTypeBuild {
+		if !in.HasBuildOption() {
+			return ErrorCmdMissingBuildOption
+		}
+	} else if !in.HasScripts() {
 		return ErrorCmdMissingScripts
 	}
 
@@ -230,21 +360,99 @@ func verifyAndInitShellCmd(in *domain.CmdIn) error {
 	return nil
 }
 
+// load plugin and verify checksum from server if the version is pinned, return plugin dir
+func loadPlugin(plugin string) (string, error) {
+	config := config.GetInstance()
+	plugins := util.NewPlugins(config.PluginDir, config.Server)
+	dir := plugins.Dir(plugin)
+
+	err := plugins.Load(plugin)
+	if err != nil {
+		return dir, err
+	}
+
+	name, version := util.ParsePlugin(plugin)
+	if !config.VerifyPlugin || util.IsEmptyString(version) {
+		return dir, nil
+	}
+
+	expected, err := config.Client.GetPluginChecksum(name, version)
+	if err != nil {
+		return dir, err
+	}
+
+	actual, err := plugins.Checksum(plugin)
+	if err != nil {
+		return dir, err
+	}
+
+	if expected != actual {
+		_ = os.RemoveAll(dir)
+		return dir, fmt.Errorf("agent: checksum of plugin '%s' mismatched, expected %s but %s", plugin, expected, actual)
+	}
+
+	util.LogDebug("Plugin '%s' checksum verified", plugin)
+	return dir, nil
+}
+
+// validate inputs and run plugin script by manifest, skip if plugin has no manifest
+func applyPluginManifest(in *domain.CmdIn, dir string) error {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, domain.PluginManifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	plugin, err := domain.NewPluginFromYaml(raw)
+	if err != nil {
+		return err
+	}
+
+	if in.Inputs == nil {
+		in.Inputs = make(domain.Variables, 10)
+	}
+
+	if err = plugin.ApplyInputs(in.Inputs); err != nil {
+		return err
+	}
+
+	if plugin.HasScript() {
+		script := fmt.Sprintf("source \"${%s}\"/%s", domain.VarPluginPath, util.ShellQuote(plugin.Script))
+		in.Scripts = append(in.Scripts, script)
+	}
+
+	for _, export := range plugin.Exports {
+		in.EnvFilters = append(in.EnvFilters, domain.EnvFilterExact+export)
+	}
+
+	if plugin.HasDocker() && !in.HasDockerOption() {
+		in.Docker = &domain.DockerOption{
+			Image:             plugin.Docker.Image,
+			IsStopContainer:   true,
+			IsDeleteContainer: true,
+		}
+	}
+
+	return nil
+}
+
 // Save result to local db and send back the result to server
 func saveAndPushBack(r *domain.ExecutedCmd) {
 	config := config.GetInstance()
 
-	// TODO: save to local db
-
-	if !config.HasQueue() {
+	if config.Settings == nil {
 		return
 	}
 
-	queue := config.Queue
 	json, _ := json.Marshal(r)
 	callback := config.Settings.Queue.Callback
 
-	err := queue.Channel.Publish("", callback, false, false, amqp.Publishing{
+	err := GetOutboxService().Publish(&domain.OutboxMessage{
+		Kind:        domain.OutboxKindResult,
+		RoutingKey:  callback,
 		ContentType: util.HttpMimeJson,
 		Body:        json,
 	})
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -51,20 +53,26 @@ func init() {
 func TestShouldReceiveExecutedCmdCallbackMessage(t *testing.T) {
 	assert := assert.New(t)
 
-	// init:
+	// init: agent db is created in workspace
+	dir, _ := ioutil.TempDir("", "agent_service_test_")
+	defer os.RemoveAll(dir)
+
 	config := config.GetInstance()
 	config.Server = ts.URL
 	config.Token = "ca9b8be2-c0e5-4b86-8fdc-b92d921597a0"
 	config.Port = 8081
-	config.Init()
+	config.Workspace = dir
+	config.LoggingDir = filepath.Join(dir, ".logs")
+	config.PluginDir = filepath.Join(dir, ".plugins")
+	assert.NoError(config.Init())
 
 	defer config.Close()
 	assert.True(config.HasQueue())
-	assert.NotNil(config.Queue)
+	assert.NotNil(config.GetQueue())
 
 	// create queue consumer
 	callbackQueue := config.Settings.Queue.Callback
-	ch := config.Queue.Channel
+	ch := config.GetQueue().Channel
 	_, _ = ch.QueueDeclare(callbackQueue, false, true, false, false, nil)
 	defer func() {
 		_, err := ch.QueueDelete(callbackQueue, false, false, true)
@@ -96,3 +104,23 @@ func TestShouldReceiveExecutedCmdCallbackMessage(t *testing.T) {
 		assert.Fail("timeout..")
 	}
 }
+
+func TestShouldApplyPluginManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "agent_plugin_")
+	defer os.RemoveAll(dir)
+
+	manifest := "name: demo\ninputs:\n  - name: DEMO_INPUT\n    required: true\nexports:\n  - DEMO_OUT\nscript: run.sh\n"
+	_ = ioutil.WriteFile(filepath.Join(dir, domain.PluginManifestFile), []byte(manifest), 0644)
+
+	// should fail fast if required input is missing
+	in := &domain.CmdIn{Cmd: domain.Cmd{Plugin: "demo"}}
+	assert.Error(applyPluginManifest(in, dir))
+
+	// should append plugin script and exports
+	in = &domain.CmdIn{Cmd: domain.Cmd{Plugin: "demo"}, Inputs: domain.Variables{"DEMO_INPUT": "hello"}}
+	assert.NoError(applyPluginManifest(in, dir))
+	assert.Equal([]string{"source \"${FLOWCI_PLUGIN_PATH}\"/'run.sh'"}, in.Scripts)
+	assert.Equal([]string{"=DEMO_OUT"}, in.EnvFilters)
+}
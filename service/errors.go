@@ -7,6 +7,15 @@ var (
 	ErrorCmdMissingScripts  = errors.New("agent: the cmd missing shell script")
 	ErrorCmdUnsupportedType = errors.New("agent: unsupported cmd type")
 
+	ErrorCmdMissingCheckoutOption = errors.New("agent: the checkout option is missing")
+	ErrorCmdMissingBuildOption    = errors.New("agent: the build option is missing")
+
+	ErrorQueueNotConnected = errors.New("agent: rabbitmq is not connected")
+	ErrorZkNotConnected    = errors.New("agent: zookeeper is not connected")
+
+	ErrorOutboxNotAvailable = errors.New("agent: outbox is not available")
+	ErrorOutboxFull         = errors.New("agent: outbox is full")
+
 	ErrorCmdScriptIsPersented     = errors.New("agent: the scripts should be empty for session open")
 	ErrorCmdMissingSessionID      = errors.New("agent: the session id is required for cmd")
 	ErrorCmdSessionNotFound       = errors.New("agent: session not found")
@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	gcDockerTimeout = 5 * time.Minute
+
+	// image to list and remove flow dirs in agent volume
+	gcVolumeImage = "busybox:latest"
+	gcVolumeMount = "/ws"
+
+	// print '{modified time} {size in KB} {path}' of each flow dir in volume, hidden dirs are not matched by glob
+	gcVolumeListScript = `for d in /ws/*/; do [ -d "$d" ] && echo "$(stat -c %Y "$d") $(du -sk "$d" | cut -f1) ${d%/}"; done; exit 0`
+)
+
+var (
+	gcSingleton *GCService
+	gcOnce      sync.Once
+)
+
+type (
+	// GCService clean workspace, logs, containers, images and plugins periodically
+	GCService struct {
+		mux sync.Mutex
+	}
+
+	// RetentionPolicy of dirs, each rule is disabled if <= 0
+	RetentionPolicy struct {
+		MaxAge  time.Duration
+		MaxSize int64 // in bytes
+		Keep    int
+	}
+
+	dirEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+)
+
+// GetGCService get singleton of gc service, and start gc in background
+func GetGCService() *GCService {
+	gcOnce.Do(func() {
+		gcSingleton = new(GCService)
+		gcSingleton.start()
+	})
+	return gcSingleton
+}
+
+// Run gc once, the workspace will be skipped if cmd is running
+func (s *GCService) Run() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	config := config.GetInstance()
+	util.LogDebug("[GC]: start")
+
+	s.cleanWorkspace(config)
+	s.cleanLogs(config)
+	s.cleanPlugins(config)
+	s.cleanDocker(config)
+
+	util.LogDebug("[GC]: done")
+}
+
+func (s *GCService) start() {
+	config := config.GetInstance()
+
+	if config.GCInterval <= 0 {
+		return
+	}
+
+	go func() {
+		defer util.LogDebug("[Exit]: GC service")
+
+		for {
+			select {
+			case <-config.AppCtx.Done():
+				return
+			case <-time.After(config.GCInterval):
+				s.Run()
+			}
+		}
+	}()
+}
+
+// remove flow dirs by retention policy, hidden dirs like .logs and .plugins are excluded
+func (s *GCService) cleanWorkspace(config *config.Manager) {
+	policy := RetentionPolicy{
+		MaxAge:  config.WorkspaceMaxAge,
+		MaxSize: config.WorkspaceMaxSize * 1024 * 1024,
+		Keep:    config.WorkspaceKeep,
+	}
+
+	if !policy.isEnabled() {
+		return
+	}
+
+	entries, err := listDirs(config.Workspace, true)
+	if !util.LogIfError(err) {
+		s.removeIfNotRunning(policy.expired(entries, time.Now()))
+	}
+
+	s.cleanAgentVolume(config, policy)
+}
+
+func (s *GCService) removeIfNotRunning(entries []*dirEntry) {
+	cmdService := GetCmdService()
+
+	// hold the lock to avoid cmd started on the dir which is going to be removed
+	cmdService.mux.Lock()
+	defer cmdService.mux.Unlock()
+
+	if cmdService.IsRunning() {
+		util.LogDebug("[GC]: skip workspace since cmd is running")
+		return
+	}
+
+	removeDirs(entries)
+}
+
+// remove flow dirs in agent volume, which is the workspace of docker steps in volume mode
+func (s *GCService) cleanAgentVolume(config *config.Manager, policy RetentionPolicy) {
+	ctx, cancel := context.WithTimeout(config.AppCtx, gcDockerTimeout)
+	defer cancel()
+
+	cli := dockerClientOf(ctx)
+	if cli == nil {
+		return
+	}
+	defer cli.Close()
+
+	name := domain.DockerAgentVolumePrefix + config.Token
+	if _, err := cli.VolumeInspect(ctx, name); err != nil {
+		return
+	}
+
+	output, err := runInVolume(ctx, cli, config.Token, name, []string{"sh", "-c", gcVolumeListScript})
+	if util.LogIfError(err) {
+		return
+	}
+
+	// the cmd lock is not held while running containers, so skip the dir of running cmd
+	running := util.EmptyStr
+	if flowId := GetCmdService().RunningFlowId(); !util.IsEmptyString(flowId) {
+		running = path.Join(gcVolumeMount, util.ParseString(flowId))
+	}
+
+	cmd := []string{"rm", "-rf"}
+	for _, entry := range policy.expired(parseVolumeDirs(output), time.Now()) {
+		if entry.path != running {
+			cmd = append(cmd, entry.path)
+		}
+	}
+
+	if len(cmd) == 2 {
+		return
+	}
+
+	_, err = runInVolume(ctx, cli, config.Token, name, cmd)
+	if !util.LogIfError(err) {
+		util.LogInfo("[GC]: %v removed from volume %s", cmd[2:], name)
+	}
+}
+
+func (s *GCService) cleanLogs(config *config.Manager) {
+	if config.GCMaxAge <= 0 {
+		return
+	}
+
+	files, err := ioutil.ReadDir(config.LoggingDir)
+	if util.LogIfError(err) {
+		return
+	}
+
+	deadline := time.Now().Add(-config.GCMaxAge)
+	for _, f := range files {
+		if f.IsDir() || f.ModTime().After(deadline) {
+			continue
+		}
+
+		path := filepath.Join(config.LoggingDir, f.Name())
+		if isUploadPending(path) {
+			continue
+		}
+
+		if !util.LogIfError(os.Remove(path)) {
+			util.LogInfo("[GC]: log '%s' removed", path)
+		}
+	}
+}
+
+// remove pinned plugin versions which are not used within max age
+func (s *GCService) cleanPlugins(config *config.Manager) {
+	if config.GCMaxAge <= 0 {
+		return
+	}
+
+	entries, err := listDirs(config.PluginDir, false)
+	if util.LogIfError(err) {
+		return
+	}
+
+	var versions []*dirEntry
+	for _, entry := range entries {
+		if _, version := util.ParsePlugin(filepath.Base(entry.path)); !util.IsEmptyString(version) {
+			versions = append(versions, entry)
+		}
+	}
+
+	policy := RetentionPolicy{MaxAge: config.GCMaxAge}
+	removeDirs(policy.expired(versions, time.Now()))
+}
+
+// remove stopped step containers created by agent and dangling images
+func (s *GCService) cleanDocker(config *config.Manager) {
+	if config.GCMaxAge <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(config.AppCtx, gcDockerTimeout)
+	defer cancel()
+
+	cli := dockerClientOf(ctx)
+	if cli == nil {
+		return
+	}
+	defer cli.Close()
+
+	args := filters.NewArgs()
+	args.Add("label", domain.DockerLabelAgent+"="+config.Token)
+	args.Add("status", "exited")
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if util.LogIfError(err) {
+		return
+	}
+
+	deadline := time.Now().Add(-config.GCMaxAge)
+	for _, c := range containers {
+		if time.Unix(c.Created, 0).After(deadline) {
+			continue
+		}
+
+		err = cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		if !util.LogIfError(err) {
+			util.LogInfo("[GC]: container %s removed", c.ID)
+		}
+	}
+
+	dangling := filters.NewArgs()
+	dangling.Add("dangling", "true")
+
+	report, err := cli.ImagesPrune(ctx, dangling)
+	if !util.LogIfError(err) && len(report.ImagesDeleted) > 0 {
+		util.LogInfo("[GC]: %d dangling images removed, %d bytes reclaimed", len(report.ImagesDeleted), report.SpaceReclaimed)
+	}
+}
+
+func (p RetentionPolicy) isEnabled() bool {
+	return p.MaxAge > 0 || p.MaxSize > 0 || p.Keep > 0
+}
+
+// expired dirs by policy, the max age rule is applied first, then keep N and total size from the most recent
+func (p RetentionPolicy) expired(entries []*dirEntry, now time.Time) []*dirEntry {
+	sorted := make([]*dirEntry, len(entries))
+	copy(sorted, entries)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].modTime.After(sorted[j].modTime)
+	})
+
+	var expired []*dirEntry
+	var total int64
+	kept := 0
+
+	for _, entry := range sorted {
+		isExpired := (p.MaxAge > 0 && now.Sub(entry.modTime) > p.MaxAge) ||
+			(p.Keep > 0 && kept >= p.Keep) ||
+			(p.MaxSize > 0 && total+entry.size > p.MaxSize)
+
+		if isExpired {
+			expired = append(expired, entry)
+			continue
+		}
+
+		kept++
+		total += entry.size
+	}
+
+	return expired
+}
+
+// list sub dirs, the size is calculated only if withSize
+func listDirs(dir string, withSize bool) ([]*dirEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*dirEntry
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		entry := &dirEntry{
+			path:    filepath.Join(dir, f.Name()),
+			modTime: f.ModTime(),
+		}
+
+		if withSize {
+			entry.size = dirSize(entry.path)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func dirSize(dir string) (size int64) {
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+func removeDirs(entries []*dirEntry) {
+	for _, entry := range entries {
+		if !util.LogIfError(os.RemoveAll(entry.path)) {
+			util.LogInfo("[GC]: '%s' removed", entry.path)
+		}
+	}
+}
+
+// docker client if daemon is available, otherwise nil
+func dockerClientOf(ctx context.Context) *client.Client {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return nil
+	}
+
+	if _, err = cli.Ping(ctx); err != nil {
+		cli.Close()
+		return nil
+	}
+
+	return cli
+}
+
+// run cmd in a temp container with volume mounted, and return the stdout
+func runInVolume(ctx context.Context, cli *client.Client, agentId, volume string, cmd []string) (string, error) {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, gcVolumeImage); err != nil {
+		reader, err := cli.ImagePull(ctx, gcVolumeImage, types.ImagePullOptions{})
+		if err != nil {
+			return "", err
+		}
+
+		_, _ = io.Copy(ioutil.Discard, reader)
+		reader.Close()
+	}
+
+	config := &container.Config{
+		Image:  gcVolumeImage,
+		Cmd:    cmd,
+		Labels: map[string]string{domain.DockerLabelAgent: agentId},
+	}
+
+	hostConfig := &container.HostConfig{
+		Binds: []string{volume + ":" + gcVolumeMount},
+	}
+
+	created, err := cli.ContainerCreate(ctx, config, hostConfig, nil, "")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = cli.ContainerRemove(context.Background(), created.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	if err = cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return "", err
+	}
+
+	code, err := cli.ContainerWait(ctx, created.ID)
+	if err != nil {
+		return "", err
+	}
+
+	logs, err := cli.ContainerLogs(ctx, created.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err = stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return "", err
+	}
+
+	if code != 0 {
+		return "", fmt.Errorf("agent: gc container exit with %d: %s", code, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// parse dir entries from output of gcVolumeListScript
+func parseVolumeDirs(output string) []*dirEntry {
+	var entries []*dirEntry
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		modTime, timeErr := strconv.ParseInt(fields[0], 10, 64)
+		size, sizeErr := strconv.ParseInt(fields[1], 10, 64)
+		if timeErr != nil || sizeErr != nil {
+			continue
+		}
+
+		entries = append(entries, &dirEntry{
+			path:    fields[2],
+			size:    size * 1024,
+			modTime: time.Unix(modTime, 0),
+		})
+	}
+
+	return entries
+}
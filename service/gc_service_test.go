@@ -0,0 +1,71 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github/flowci/flow-agent-x/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldSelectExpiredDirsByPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	entries := []*dirEntry{
+		{path: "c", size: 30, modTime: now.Add(-3 * time.Hour)},
+		{path: "a", size: 10, modTime: now.Add(-1 * time.Hour)},
+		{path: "d", size: 40, modTime: now.Add(-4 * 24 * time.Hour)},
+		{path: "b", size: 20, modTime: now.Add(-2 * time.Hour)},
+	}
+
+	paths := func(entries []*dirEntry) (list []string) {
+		for _, e := range entries {
+			list = append(list, e.path)
+		}
+		return
+	}
+
+	assert.Equal([]string{"d"}, paths(RetentionPolicy{MaxAge: 24 * time.Hour}.expired(entries, now)))
+	assert.Equal([]string{"c", "d"}, paths(RetentionPolicy{Keep: 2}.expired(entries, now)))
+	assert.Equal([]string{"c", "d"}, paths(RetentionPolicy{MaxSize: 35}.expired(entries, now)))
+	assert.Nil(RetentionPolicy{}.expired(entries, now))
+}
+
+func TestShouldListDirsWithSizeAndSkipHidden(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "agent_gc_test_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "flow-1", "src"), os.ModePerm))
+	assert.NoError(os.MkdirAll(filepath.Join(dir, ".logs"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "flow-1", "src", "file"), make([]byte, 100), 0644))
+
+	entries, err := listDirs(dir, true)
+	assert.NoError(err)
+	assert.Equal(1, len(entries))
+	assert.Equal(filepath.Join(dir, "flow-1"), entries[0].path)
+	assert.Equal(int64(100), entries[0].size)
+
+	removeDirs(entries)
+	assert.False(util.IsFileExists(entries[0].path))
+}
+
+func TestShouldParseDirsInVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	output := "1600000000 4 /ws/flow-1\n1600000100 2048 /ws/flow 2\ninvalid\n"
+
+	entries := parseVolumeDirs(output)
+	assert.Equal(2, len(entries))
+	assert.Equal("/ws/flow-1", entries[0].path)
+	assert.Equal(int64(4096), entries[0].size)
+	assert.Equal(time.Unix(1600000000, 0), entries[0].modTime)
+	assert.Equal("/ws/flow 2", entries[1].path)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/shirou/gopsutil/disk"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	healthCheckDisk      = "disk"
+	healthCheckDocker    = "docker"
+	healthCheckWorkspace = "workspace"
+	healthCheckQueue     = "rabbitmq"
+	healthCheckZk        = "zookeeper"
+
+	healthCheckTimeout = 10 * time.Second
+)
+
+var (
+	healthSingleton *HealthService
+	healthOnce      sync.Once
+)
+
+type (
+	// HealthService run host checks periodically, the agent stop consuming jobs if any check failed
+	HealthService struct {
+		mux      sync.RWMutex
+		checks   []*domain.HealthCheck
+		checkers []healthChecker
+	}
+
+	healthChecker struct {
+		name  string
+		check func(config *config.Manager) (domain.HealthStatus, error)
+	}
+)
+
+// GetHealthService get singleton of health service, and start checks in background
+func GetHealthService() *HealthService {
+	healthOnce.Do(func() {
+		healthSingleton = newHealthService()
+		healthSingleton.start()
+	})
+	return healthSingleton
+}
+
+func newHealthService() *HealthService {
+	return &HealthService{
+		checkers: []healthChecker{
+			{name: healthCheckDisk, check: checkDisk},
+			{name: healthCheckDocker, check: checkDocker},
+			{name: healthCheckWorkspace, check: checkWorkspace},
+			{name: healthCheckQueue, check: checkQueue},
+			{name: healthCheckZk, check: checkZookeeper},
+		},
+	}
+}
+
+// Checks result of the latest host checks
+func (s *HealthService) Checks() []*domain.HealthCheck {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.checks
+}
+
+// Run host checks once and update health status of agent
+func (s *HealthService) Run() bool {
+	config := config.GetInstance()
+	checks := make([]*domain.HealthCheck, len(s.checkers))
+	healthy := true
+
+	for i, checker := range s.checkers {
+		status, err := checker.check(config)
+
+		checks[i] = &domain.HealthCheck{
+			Name:      checker.name,
+			Status:    status,
+			CheckedAt: time.Now(),
+		}
+
+		if err != nil {
+			checks[i].Message = err.Error()
+		}
+
+		if checks[i].IsFailed() {
+			healthy = false
+			util.LogWarn("Host check '%s' failed: %s", checker.name, checks[i].Message)
+		}
+	}
+
+	s.mux.Lock()
+	s.checks = checks
+	s.mux.Unlock()
+
+	wasHealthy := config.IsHealthy()
+	config.SetHealthy(healthy)
+
+	// stop consuming jobs, the consumer will be resumed once checks passed
+	if wasHealthy && !healthy {
+		GetCmdService().pauseConsume()
+	}
+
+	return healthy
+}
+
+func (s *HealthService) start() {
+	config := config.GetInstance()
+
+	if config.HealthInterval <= 0 {
+		return
+	}
+
+	go func() {
+		defer util.LogDebug("[Exit]: Health service")
+
+		for {
+			s.Run()
+
+			select {
+			case <-config.AppCtx.Done():
+				return
+			case <-time.After(config.HealthInterval):
+			}
+		}
+	}()
+}
+
+func checkDisk(config *config.Manager) (domain.HealthStatus, error) {
+	if config.MinFreeDisk == 0 {
+		return domain.HealthSkipped, nil
+	}
+
+	stat, err := disk.Usage(config.Workspace)
+	if err != nil {
+		return domain.HealthFailed, err
+	}
+
+	free := util.ByteToMB(stat.Free)
+	if free < config.MinFreeDisk {
+		return domain.HealthFailed, fmt.Errorf("free disk %d MB is lower than %d MB", free, config.MinFreeDisk)
+	}
+
+	return domain.HealthPassed, nil
+}
+
+func checkDocker(config *config.Manager) (domain.HealthStatus, error) {
+	if !config.CheckDocker {
+		return domain.HealthSkipped, nil
+	}
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return domain.HealthFailed, err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(config.AppCtx, healthCheckTimeout)
+	defer cancel()
+
+	if _, err = cli.Ping(ctx); err != nil {
+		return domain.HealthFailed, err
+	}
+
+	return domain.HealthPassed, nil
+}
+
+func checkWorkspace(config *config.Manager) (domain.HealthStatus, error) {
+	f, err := ioutil.TempFile(config.Workspace, ".health_")
+	if err != nil {
+		return domain.HealthFailed, err
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err = f.WriteString("ok"); err != nil {
+		_ = f.Close()
+		return domain.HealthFailed, err
+	}
+
+	if err = f.Close(); err != nil {
+		return domain.HealthFailed, err
+	}
+
+	return domain.HealthPassed, nil
+}
+
+func checkQueue(config *config.Manager) (domain.HealthStatus, error) {
+	queue := config.GetQueue()
+	if queue == nil || queue.IsClosed() {
+		return domain.HealthFailed, ErrorQueueNotConnected
+	}
+
+	return domain.HealthPassed, nil
+}
+
+func checkZookeeper(config *config.Manager) (domain.HealthStatus, error) {
+	zk := config.GetZk()
+	if zk == nil || !zk.IsConnected() {
+		return domain.HealthFailed, ErrorZkNotConnected
+	}
+
+	return domain.HealthPassed, nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldBeUnhealthyIfQueueNotConnected(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "agent_health_test_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := config.GetInstance()
+	previous := config.Workspace
+	config.Workspace = dir
+
+	defer func() {
+		config.Workspace = previous
+		config.SetHealthy(true)
+	}()
+
+	health := newHealthService()
+	assert.False(health.Run())
+	assert.False(config.IsHealthy())
+
+	status := make(map[string]domain.HealthStatus)
+	for _, check := range health.Checks() {
+		status[check.Name] = check.Status
+	}
+
+	assert.Equal(domain.HealthSkipped, status[healthCheckDisk])
+	assert.Equal(domain.HealthSkipped, status[healthCheckDocker])
+	assert.Equal(domain.HealthPassed, status[healthCheckWorkspace])
+	assert.Equal(domain.HealthFailed, status[healthCheckQueue])
+	assert.Equal(domain.HealthFailed, status[healthCheckZk])
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"io"
+	"regexp"
+)
+
+const (
+	// the line is written without waiting for line break if it's too long
+	logCleanerMaxLineSize = 64 * 1024
+)
+
+var (
+	// CSI sequence for colors and cursor, OSC sequence for title, and other escape sequence
+	ansiPattern = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+)
+
+type (
+	// logCleaner write log line by line, the carriage return collapsed so only the last state of progress bar kept,
+	// and ANSI escape sequence removed if strip enabled
+	logCleaner struct {
+		writer    io.Writer
+		stripAnsi bool
+		line      []byte
+		pendingCR bool // carriage return at the end of last content, which could be followed by line break
+	}
+)
+
+func newLogCleaner(writer io.Writer, stripAnsi bool) *logCleaner {
+	return &logCleaner{
+		writer:    writer,
+		stripAnsi: stripAnsi,
+	}
+}
+
+func (c *logCleaner) Write(content []byte) (int, error) {
+	for _, b := range content {
+		if c.pendingCR {
+			c.pendingCR = false
+
+			// line is overwritten by the content after carriage return
+			if b != '\n' {
+				c.line = c.line[:0]
+			}
+		}
+
+		switch b {
+		case '\r':
+			c.pendingCR = true
+		case '\n':
+			c.line = append(c.line, b)
+			if err := c.writeLine(); err != nil {
+				return 0, err
+			}
+		default:
+			c.line = append(c.line, b)
+			if len(c.line) >= logCleanerMaxLineSize {
+				if err := c.writeLine(); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return len(content), nil
+}
+
+// Flush write the rest of line
+func (c *logCleaner) Flush() error {
+	c.pendingCR = false
+	return c.writeLine()
+}
+
+func (c *logCleaner) writeLine() error {
+	line := c.line
+	if c.stripAnsi {
+		line = ansiPattern.ReplaceAll(line, nil)
+	}
+
+	c.line = c.line[:0]
+	_, err := c.writer.Write(line)
+	return err
+}
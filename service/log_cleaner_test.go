@@ -0,0 +1,39 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldCollapseCarriageReturnInLog(t *testing.T) {
+	assert := assert.New(t)
+
+	var buffer bytes.Buffer
+	cleaner := newLogCleaner(&buffer, false)
+
+	// progress bar written in chunks, and line break of tty is '\r\n'
+	for _, chunk := range []string{"start\r\n", "10%\r", "50%\r", "100%\r", "\ndone\r", "\nrest"} {
+		_, err := cleaner.Write([]byte(chunk))
+		assert.NoError(err)
+	}
+
+	assert.Equal("start\n100%\ndone\n", buffer.String())
+
+	assert.NoError(cleaner.Flush())
+	assert.Equal("start\n100%\ndone\nrest", buffer.String())
+}
+
+func TestShouldStripAnsiEscapeSequenceInLog(t *testing.T) {
+	assert := assert.New(t)
+
+	var buffer bytes.Buffer
+	cleaner := newLogCleaner(&buffer, true)
+
+	// the sequence is split across chunks
+	_, _ = cleaner.Write([]byte("\x1b[1;3"))
+	_, _ = cleaner.Write([]byte("1mred\x1b[0m \x1b]0;title\x07text\x1b[2K\n"))
+
+	assert.Equal("red text\n", buffer.String())
+}
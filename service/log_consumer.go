@@ -2,42 +2,45 @@ package service
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"github/flowci/flow-agent-x/executor"
-	"io"
-	"io/ioutil"
-	"mime/multipart"
-	"net/http"
 	"os"
 	"path/filepath"
 
-	"github.com/streadway/amqp"
 	"github/flowci/flow-agent-x/config"
 	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/executor"
 	"github/flowci/flow-agent-x/util"
 )
 
-var (
-	logBuffer = bytes.NewBuffer(make([]byte, 1024 * 1024 * 1)) // 1mb buffer
-)
-
-// Push stdout, stderr log back to server
-func logConsumer(executor executor.Executor, logDir string) {
+// Push stdout, stderr log back to server, the live log stopped and the log file keeps head and tail if log exceeds max size.
+// The log file is cleaned by collapsing carriage returns and stripping ANSI codes if enabled, the live log is kept as it is
+func logConsumer(executor executor.Executor, logDir string, limit logLimitOptions) {
 	config := config.GetInstance()
 	logChannel := executor.LogChannel()
 
 	// init path for shell, log and raw log
 	logPath := filepath.Join(logDir, executor.CmdId()+".log")
 	f, _ := os.Create(logPath)
-	writer := bufio.NewWriter(f)
+	buffered := bufio.NewWriter(f)
+	writer := newLogCleaner(buffered, config.LogStripAnsi)
+
+	shipper := newLogShipperOfCmd(config, executor.CmdId(), executor.JobId(), logDir)
+	truncator := newLogTruncator(limit.MaxSize, defaultLogTailSize)
 
 	// upload log after flush!!
 	defer func() {
+		if truncator.Exceeded() {
+			_, _ = writer.Write(truncator.Tail())
+		}
+
 		_ = writer.Flush()
+		_ = buffered.Flush()
 		_ = f.Close()
 
+		if shipper != nil {
+			shipper.Close()
+		}
+
 		err := uploadLog(logPath)
 		util.LogIfError(err)
 
@@ -50,76 +53,92 @@ func logConsumer(executor executor.Executor, logDir string) {
 			break
 		}
 
-		writer.Write(log.Content)
 		util.LogDebug("[LOG]: %s", log.Content)
 
-		pushLog(config, log)
+		head, exceeded := truncator.Add(log.Content)
+		_, _ = writer.Write(head)
+
+		if shipper != nil && len(head) > 0 {
+			shipper.Add(log.Stream, head)
+		}
+
+		if exceeded {
+			onLogExceeded(executor, shipper, limit)
+		}
 	}
 }
 
-func pushLog(config *config.Manager, log *domain.LogItem) {
-	if !config.HasQueue() {
-		return
+// stop live log with notice, and kill cmd if it's enabled
+func onLogExceeded(executor executor.Executor, shipper *logShipper, limit logLimitOptions) {
+	notice := fmt.Sprintf("\n[flow.ci] Log exceeds max size %d bytes, live log stopped\n", limit.MaxSize)
+	if limit.Kill {
+		notice = fmt.Sprintf("\n[flow.ci] Log exceeds max size %d bytes, cmd will be killed\n", limit.MaxSize)
 	}
 
-	defer logBuffer.Reset()
+	util.LogWarn("[Log]: log of cmd %s exceeds max size %d bytes", executor.CmdId(), limit.MaxSize)
 
-	exchange := config.Settings.Queue.LogsExchange
-	channel := config.Queue.LogChannel
+	if shipper != nil {
+		shipper.Add(domain.LogStream_SYSTEM, []byte(notice))
+	}
 
-	_ = channel.Publish(exchange, "", false, false, amqp.Publishing{
-		ContentType: util.HttpProtobuf,
-		Body:        log.Write(logBuffer),
-	})
+	if limit.Kill {
+		executor.Kill()
+	}
 }
 
-func uploadLog(logFile string) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = r.(error)
-		}
-	}()
-
-	config := config.GetInstance()
-
-	// read file
-	file, err := os.Open(logFile)
-	util.PanicIfErr(err)
+// log shipper to push log back to server, nil if settings not loaded
+func newLogShipperOfCmd(config *config.Manager, cmdId, jobId, logDir string) *logShipper {
+	if config.Settings == nil {
+		return nil
+	}
 
-	defer file.Close()
+	options := logShipOptions{
+		BatchSize:     config.LogBatchSize,
+		BatchInterval: config.LogBatchInterval,
+		BufferSize:    config.LogBufferSize * 1024 * 1024,
+		Overflow:      config.LogOverflow,
+		SpillDir:      logDir,
+	}
 
-	// construct multi part
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	return newLogShipper(cmdId, options, func(msg *domain.LogMessage) error {
+		msg.JobId = jobId
+		return pushLog(config, msg)
+	})
+}
 
-	part, err := writer.CreateFormFile("file", filepath.Base(logFile))
-	util.PanicIfErr(err)
+func pushLog(config *config.Manager, msg *domain.LogMessage) error {
+	body, err := domain.EncodeLogMessage(msg)
+	if err != nil {
+		return err
+	}
 
-	_, err = io.Copy(part, file)
-	util.PanicIfErr(err)
+	encoding := ""
 
-	// flush file to writer
-	writer.Close()
+	if config.LogCompress {
+		compressed, err := gzipBytes(body)
+		if err != nil {
+			return err
+		}
 
-	// send request
-	url := config.Server + "/agents/logs/upload"
-	request, _ := http.NewRequest("POST", url, body)
-	request.Header.Set(util.HttpHeaderAgentToken, config.Token)
-	request.Header.Set(util.HttpHeaderContentType, writer.FormDataContentType())
+		body = compressed
+		encoding = logEncodingGzip
+	}
 
-	response, err := http.DefaultClient.Do(request)
-	util.PanicIfErr(err)
+	return GetOutboxService().Publish(&domain.OutboxMessage{
+		Kind:        domain.OutboxKindLog,
+		Exchange:    config.Settings.Queue.LogsExchange,
+		ContentType: util.HttpProtobuf,
+		Encoding:    encoding,
+		Body:        body,
+	})
+}
 
-	// get response data
-	raw, _ := ioutil.ReadAll(response.Body)
-	var message domain.Response
-	err = json.Unmarshal(raw, &message)
-	util.PanicIfErr(err)
+func uploadLog(logFile string) error {
+	config := config.GetInstance()
 
-	if message.IsOk() {
-		util.LogDebug("[Uploaded]: %s", logFile)
+	if config.Client == nil {
 		return nil
 	}
 
-	return fmt.Errorf(message.Message)
-}
\ No newline at end of file
+	return GetOutboxService().Upload(logFile)
+}
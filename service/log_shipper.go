@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	defaultLogBatchSize     = 32 * 1024
+	defaultLogBatchInterval = 500 * time.Millisecond
+	defaultLogBufferSize    = 8 * 1024 * 1024
+
+	logEncodingGzip = "gzip"
+
+	// frame header in spill file: stream (1 byte), timestamp (8 bytes), length of content (4 bytes)
+	logSpillHeaderSize = 13
+)
+
+type (
+	logShipOptions struct {
+		BatchSize     int           // max bytes of log content in one message
+		BatchInterval time.Duration // time window to batch log content
+		BufferSize    int           // max bytes of pending log content in memory
+		Overflow      string        // drop or spill to disk if buffer is full
+		SpillDir      string
+	}
+
+	// logShipper batch log content of cmd by stream and send in background, the Add never blocks on sending
+	logShipper struct {
+		mux     sync.Mutex
+		cmdId   string
+		options logShipOptions
+		send    func(msg *domain.LogMessage) error
+		seq     uint64 // only accessed in run
+
+		chunks  []*logChunk
+		size    int // bytes of chunks in memory
+		dropped int64
+
+		// content after buffer been full, which is sent once buffer drained to keep the order
+		spill      *os.File
+		spillRead  int64
+		spillWrite int64
+
+		ready  chan struct{}
+		closed chan struct{}
+		done   chan struct{}
+	}
+
+	logChunk struct {
+		stream    domain.LogStream
+		timestamp int64
+		content   []byte
+	}
+)
+
+func newLogShipper(cmdId string, options logShipOptions, send func(msg *domain.LogMessage) error) *logShipper {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultLogBatchSize
+	}
+
+	if options.BatchInterval <= 0 {
+		options.BatchInterval = defaultLogBatchInterval
+	}
+
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultLogBufferSize
+	}
+
+	s := &logShipper{
+		cmdId:   cmdId,
+		options: options,
+		send:    send,
+		ready:   make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+// Add log content to buffer, the content will be dropped or spilled to disk if buffer is full
+func (s *logShipper) Add(stream domain.LogStream, content []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	chunk := &logChunk{
+		stream:    stream,
+		timestamp: domain.LogTimestamp(time.Now()),
+		content:   append([]byte(nil), content...),
+	}
+
+	if s.spill != nil {
+		s.writeSpill(chunk)
+		return
+	}
+
+	if s.size+len(content) > s.options.BufferSize {
+		if s.options.Overflow == domain.LogOverflowSpill && s.openSpill() {
+			s.writeSpill(chunk)
+			return
+		}
+
+		s.dropped += int64(len(content))
+		return
+	}
+
+	s.chunks = append(s.chunks, chunk)
+	s.size += len(content)
+
+	if s.size >= s.options.BatchSize {
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close send the rest of content and wait
+func (s *logShipper) Close() {
+	close(s.closed)
+	<-s.done
+
+	if s.dropped > 0 {
+		util.LogWarn("[Log]: %d bytes of log dropped for cmd %s since log shipping is slow", s.dropped, s.cmdId)
+	}
+}
+
+func (s *logShipper) run() {
+	defer close(s.done)
+	defer s.closeSpill()
+
+	for {
+		closing := false
+
+		select {
+		case <-s.ready:
+		case <-time.After(s.options.BatchInterval):
+		case <-s.closed:
+			closing = true
+		}
+
+		for msg := s.next(); msg != nil; msg = s.next() {
+			s.seq++
+			msg.Seq = s.seq
+			msg.CmdId = s.cmdId
+			util.LogIfError(s.send(msg))
+		}
+
+		if closing {
+			return
+		}
+	}
+}
+
+// next batch from memory buffer, then from spill file, the content of batch is from the same stream
+func (s *logShipper) next() *domain.LogMessage {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if len(s.chunks) > 0 {
+		return s.nextFromMemory()
+	}
+
+	if s.spill == nil {
+		return nil
+	}
+
+	// spill drained, switch back to memory buffer
+	if s.spillRead >= s.spillWrite {
+		s.closeSpill()
+		return nil
+	}
+
+	msg, err := s.nextFromSpill()
+	if util.LogIfError(err) {
+		s.closeSpill()
+		return nil
+	}
+
+	return msg
+}
+
+// the chunk will be split if the batch size reached
+func (s *logShipper) nextFromMemory() *domain.LogMessage {
+	first := s.chunks[0]
+	msg := &domain.LogMessage{
+		Stream:    first.stream,
+		Timestamp: first.timestamp,
+	}
+
+	var content []byte
+	for len(s.chunks) > 0 && len(content) < s.options.BatchSize {
+		chunk := s.chunks[0]
+		if chunk.stream != msg.Stream {
+			break
+		}
+
+		n := s.options.BatchSize - len(content)
+		if n > len(chunk.content) {
+			n = len(chunk.content)
+		}
+
+		content = append(content, chunk.content[:n]...)
+		chunk.content = chunk.content[n:]
+
+		if len(chunk.content) == 0 {
+			s.chunks[0] = nil
+			s.chunks = s.chunks[1:]
+		}
+	}
+
+	s.size -= len(content)
+	msg.Content = content
+	return msg
+}
+
+// the frame will not be split, so the batch could be larger than batch size if frame is large
+func (s *logShipper) nextFromSpill() (*domain.LogMessage, error) {
+	var msg *domain.LogMessage
+	header := make([]byte, logSpillHeaderSize)
+
+	for s.spillRead < s.spillWrite {
+		if _, err := s.spill.ReadAt(header, s.spillRead); err != nil {
+			return nil, err
+		}
+
+		stream := domain.LogStream(header[0])
+		timestamp := int64(binary.BigEndian.Uint64(header[1:9]))
+		length := binary.BigEndian.Uint32(header[9:])
+
+		if msg != nil && (stream != msg.Stream || len(msg.Content)+int(length) > s.options.BatchSize) {
+			break
+		}
+
+		content := make([]byte, length)
+		if _, err := s.spill.ReadAt(content, s.spillRead+logSpillHeaderSize); err != nil {
+			return nil, err
+		}
+
+		s.spillRead += logSpillHeaderSize + int64(length)
+
+		if msg == nil {
+			msg = &domain.LogMessage{Stream: stream, Timestamp: timestamp}
+		}
+		msg.Content = append(msg.Content, content...)
+	}
+
+	return msg, nil
+}
+
+func (s *logShipper) openSpill() bool {
+	f, err := ioutil.TempFile(s.options.SpillDir, s.cmdId+".spill_")
+	if util.LogIfError(err) {
+		return false
+	}
+
+	util.LogWarn("[Log]: log buffer is full, spill log of cmd %s to %s", s.cmdId, f.Name())
+	s.spill = f
+	s.spillRead = 0
+	s.spillWrite = 0
+	return true
+}
+
+func (s *logShipper) writeSpill(chunk *logChunk) {
+	frame := make([]byte, logSpillHeaderSize+len(chunk.content))
+	frame[0] = byte(chunk.stream)
+	binary.BigEndian.PutUint64(frame[1:9], uint64(chunk.timestamp))
+	binary.BigEndian.PutUint32(frame[9:logSpillHeaderSize], uint32(len(chunk.content)))
+	copy(frame[logSpillHeaderSize:], chunk.content)
+
+	// the incomplete frame is not counted, it will be overwritten by next frame
+	n, err := s.spill.WriteAt(frame, s.spillWrite)
+	if util.LogIfError(err) || n < len(frame) {
+		s.dropped += int64(len(chunk.content))
+		return
+	}
+
+	s.spillWrite += int64(n)
+}
+
+func (s *logShipper) closeSpill() {
+	if s.spill == nil {
+		return
+	}
+
+	_ = s.spill.Close()
+	_ = os.Remove(s.spill.Name())
+	s.spill = nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github/flowci/flow-agent-x/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldBatchLogBySize(t *testing.T) {
+	assert := assert.New(t)
+
+	var batches []string
+	shipper := newLogShipper("1-1-1", logShipOptions{BatchSize: 10, BatchInterval: time.Hour}, func(msg *domain.LogMessage) error {
+		batches = append(batches, string(msg.Content))
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		shipper.Add(domain.LogStream_STDOUT, []byte("line"+strconv.Itoa(i)+"\n"))
+	}
+	shipper.Close()
+
+	assert.Equal([]string{"line0\nline", "1\nline2\nli", "ne3\nline4\n"}, batches)
+}
+
+func TestShouldBatchLogByStreamWithSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	var messages []*domain.LogMessage
+	shipper := newLogShipper("1-1-1", logShipOptions{BatchSize: 100, BatchInterval: time.Hour}, func(msg *domain.LogMessage) error {
+		messages = append(messages, msg)
+		return nil
+	})
+
+	shipper.Add(domain.LogStream_STDOUT, []byte("out1\n"))
+	shipper.Add(domain.LogStream_STDOUT, []byte("out2\n"))
+	shipper.Add(domain.LogStream_STDERR, []byte("err1\n"))
+	shipper.Add(domain.LogStream_STDOUT, []byte("out3\n"))
+	shipper.Close()
+
+	assert.Equal(3, len(messages))
+
+	for i, expected := range []struct {
+		stream  domain.LogStream
+		content string
+	}{
+		{domain.LogStream_STDOUT, "out1\nout2\n"},
+		{domain.LogStream_STDERR, "err1\n"},
+		{domain.LogStream_STDOUT, "out3\n"},
+	} {
+		assert.Equal("1-1-1", messages[i].CmdId)
+		assert.Equal(uint64(i+1), messages[i].Seq)
+		assert.Equal(expected.stream, messages[i].Stream)
+		assert.Equal(expected.content, string(messages[i].Content))
+		assert.True(messages[i].Timestamp > 0)
+	}
+}
+
+func TestShouldSpillLogToDiskAndKeepOrderIfBufferFull(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "agent_log_spill_")
+	defer os.RemoveAll(dir)
+
+	// the sending is blocked until released
+	release := make(chan struct{})
+	var mux sync.Mutex
+	var sent bytes.Buffer
+
+	options := logShipOptions{BatchSize: 4, BatchInterval: 10 * time.Millisecond, BufferSize: 8, Overflow: domain.LogOverflowSpill, SpillDir: dir}
+	shipper := newLogShipper("1-1-1", options, func(msg *domain.LogMessage) error {
+		<-release
+		mux.Lock()
+		defer mux.Unlock()
+		sent.Write(msg.Content)
+		return nil
+	})
+
+	var expected bytes.Buffer
+	for i := 0; i < 10; i++ {
+		line := []byte(strconv.Itoa(i) + "abc")
+		expected.Write(line)
+		shipper.Add(domain.LogStream(i%2), line)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(1, len(files))
+
+	close(release)
+	shipper.Close()
+
+	// then: all content sent in order and spill file removed
+	assert.Equal(expected.String(), sent.String())
+
+	files, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(files))
+}
+
+func TestShouldDropLogIfBufferFull(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	var sent bytes.Buffer
+
+	options := logShipOptions{BatchSize: 100, BatchInterval: time.Hour, BufferSize: 8, Overflow: domain.LogOverflowDrop}
+	shipper := newLogShipper("1-1-1", options, func(msg *domain.LogMessage) error {
+		<-release
+		sent.Write(msg.Content)
+		return nil
+	})
+
+	shipper.Add(domain.LogStream_STDOUT, []byte("1234"))
+	shipper.Add(domain.LogStream_STDOUT, []byte("5678"))
+	shipper.Add(domain.LogStream_STDOUT, []byte("dropped"))
+
+	close(release)
+	shipper.Close()
+
+	assert.Equal("12345678", sent.String())
+	assert.Equal(int64(7), shipper.dropped)
+}
+
+func TestShouldGzipBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	compressed, err := gzipBytes([]byte("hello flow.ci"))
+	assert.NoError(err)
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.NoError(err)
+
+	raw, err := ioutil.ReadAll(reader)
+	assert.NoError(err)
+	assert.Equal("hello flow.ci", string(raw))
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+)
+
+const (
+	// max bytes of tail kept in log file after log truncated
+	defaultLogTailSize = 256 * 1024
+)
+
+type (
+	logLimitOptions struct {
+		MaxSize int64 // in bytes, disabled if <= 0
+		Kill    bool  // kill cmd once log exceeds max size
+	}
+
+	// logTruncator keep the head of log until max size, and the last bytes of the rest as tail
+	logTruncator struct {
+		maxSize   int64
+		tailSize  int
+		size      int64
+		tail      []byte
+		truncated int64
+	}
+)
+
+// log limit of cmd, the max log size on cmd overrides the agent config
+func logLimitOf(config *config.Manager, in *domain.CmdIn) logLimitOptions {
+	maxSize := config.MaxLogSize
+	if in.MaxLogSize > 0 {
+		maxSize = in.MaxLogSize
+	}
+
+	return logLimitOptions{
+		MaxSize: maxSize * 1024 * 1024,
+		Kill:    config.KillOnMaxLogSize || in.KillOnMaxLogSize,
+	}
+}
+
+func newLogTruncator(maxSize int64, tailSize int) *logTruncator {
+	if maxSize > 0 && int64(tailSize) > maxSize {
+		tailSize = int(maxSize)
+	}
+
+	return &logTruncator{
+		maxSize:  maxSize,
+		tailSize: tailSize,
+	}
+}
+
+// Add log content, returns the part within max size, and true if max size exceeded by the content
+func (t *logTruncator) Add(content []byte) ([]byte, bool) {
+	if t.maxSize <= 0 {
+		return content, false
+	}
+
+	exceeded := t.size <= t.maxSize && t.size+int64(len(content)) > t.maxSize
+
+	head := content
+	if remain := t.maxSize - t.size; remain < int64(len(content)) {
+		if remain < 0 {
+			remain = 0
+		}
+
+		head = content[:remain]
+		t.keepTail(content[remain:])
+	}
+
+	t.size += int64(len(content))
+	return head, exceeded
+}
+
+// Exceeded is max size exceeded
+func (t *logTruncator) Exceeded() bool {
+	return t.maxSize > 0 && t.size > t.maxSize
+}
+
+// Tail the notice of truncated size and the last bytes of log
+func (t *logTruncator) Tail() []byte {
+	notice := fmt.Sprintf("\n[flow.ci] %d bytes of log truncated since it exceeds max size %d bytes\n", t.truncated, t.maxSize)
+	return append([]byte(notice), t.tail...)
+}
+
+func (t *logTruncator) keepTail(content []byte) {
+	t.tail = append(t.tail, content...)
+
+	if over := len(t.tail) - t.tailSize; over > 0 {
+		t.truncated += int64(over)
+		t.tail = t.tail[over:]
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldKeepHeadAndTailIfLogExceedsMaxSize(t *testing.T) {
+	assert := assert.New(t)
+
+	truncator := newLogTruncator(10, 4)
+
+	head, exceeded := truncator.Add([]byte("12345"))
+	assert.Equal("12345", string(head))
+	assert.False(exceeded)
+
+	// then: exceeded on the content over max size
+	head, exceeded = truncator.Add([]byte("6789abc"))
+	assert.Equal("6789a", string(head))
+	assert.True(exceeded)
+	assert.True(truncator.Exceeded())
+
+	// then: only tail kept after exceeded
+	head, exceeded = truncator.Add([]byte("defgh"))
+	assert.Equal(0, len(head))
+	assert.False(exceeded)
+
+	tail := string(truncator.Tail())
+	assert.True(strings.HasPrefix(tail, "\n[flow.ci] 3 bytes of log truncated"))
+	assert.True(strings.HasSuffix(tail, "\nefgh"))
+}
+
+func TestShouldNotTruncateLogIfMaxSizeDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	truncator := newLogTruncator(0, defaultLogTailSize)
+
+	for i := 0; i < 10; i++ {
+		head, exceeded := truncator.Add([]byte("content"))
+		assert.Equal("content", string(head))
+		assert.False(exceeded)
+	}
+
+	assert.False(truncator.Exceeded())
+}
+
+func TestShouldOverrideMaxLogSizeByCmd(t *testing.T) {
+	assert := assert.New(t)
+
+	manager := &config.Manager{MaxLogSize: 100}
+
+	limit := logLimitOf(manager, &domain.CmdIn{})
+	assert.Equal(int64(100*1024*1024), limit.MaxSize)
+	assert.False(limit.Kill)
+
+	limit = logLimitOf(manager, &domain.CmdIn{MaxLogSize: 1, KillOnMaxLogSize: true})
+	assert.Equal(int64(1024*1024), limit.MaxSize)
+	assert.True(limit.Kill)
+}
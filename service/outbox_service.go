@@ -0,0 +1,495 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github/flowci/flow-agent-x/api"
+	"github/flowci/flow-agent-x/config"
+	"github/flowci/flow-agent-x/dao"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+)
+
+const (
+	outboxBatchSize     = 100
+	outboxFlushInterval = 10 * time.Second
+
+	// retry result publishing on nack or return before buffered
+	publishMaxRetries = 3
+	publishMinBackoff = 500 * time.Millisecond
+	publishMaxBackoff = 5 * time.Second
+
+	// marker file of log which is not uploaded, the log will not be removed by gc
+	uploadPendingSuffix = ".pending"
+)
+
+var (
+	outboxSingleton *OutboxService
+	outboxOnce      sync.Once
+)
+
+type (
+	// OutboxService send results, logs and log files to server, which are buffered in agent db while server or
+	// broker unavailable, and flushed in order once connectivity returns
+	OutboxService struct {
+		mux      sync.Mutex // guard rows and stats, not held while sending
+		db       *dao.Client
+		send     func(msg *domain.OutboxMessage) error
+		maxSize  int64 // in bytes, disabled if <= 0
+		stats    domain.OutboxStats
+		seq      int64
+		inflight map[int64]bool // seq of buffered messages being sent, which will not be evicted
+		messages *outboxLane
+		uploads  *outboxLane
+	}
+
+	// outboxLane messages in lane are sent in order by its own worker, so the slow log uploading
+	// will not block the results and logs
+	outboxLane struct {
+		name    string
+		where   string
+		sendMux sync.Mutex // serialize sending of lane
+		pending int64      // buffered count, guarded by outbox mux
+		notify  chan struct{}
+	}
+)
+
+// GetOutboxService get singleton of outbox service, and start flushing in background
+func GetOutboxService() *OutboxService {
+	outboxOnce.Do(func() {
+		config := config.GetInstance()
+		outboxSingleton = newOutboxService(config.DB, config.OutboxMaxSize*1024*1024)
+		outboxSingleton.ResumeUploads(config.LoggingDir)
+		outboxSingleton.start()
+	})
+	return outboxSingleton
+}
+
+func newOutboxService(db *dao.Client, maxSize int64) *OutboxService {
+	s := &OutboxService{
+		db:       db,
+		send:     sendOutboxMessage,
+		maxSize:  maxSize,
+		inflight: make(map[int64]bool),
+		messages: newOutboxLane("messages", "kind<>?"),
+		uploads:  newOutboxLane("uploads", "kind=?"),
+	}
+
+	if db != nil {
+		s.stats.Count, _ = db.Count(domain.OutboxMessage{}, dao.Query{})
+		s.stats.Size, _ = db.Sum(domain.OutboxMessage{}, "size", dao.Query{})
+
+		for _, lane := range s.lanes() {
+			lane.pending, _ = db.Count(domain.OutboxMessage{}, lane.query(0))
+		}
+	}
+
+	return s
+}
+
+func newOutboxLane(name, where string) *outboxLane {
+	return &outboxLane{
+		name:   name,
+		where:  where,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Publish send message to rabbitmq, it will be buffered if failed or previous messages not sent
+func (s *OutboxService) Publish(msg *domain.OutboxMessage) error {
+	msg.Size = int64(len(msg.Body))
+	return s.deliver(msg)
+}
+
+// Upload log file to server, it will be buffered if server unavailable, and the file is kept until uploaded
+func (s *OutboxService) Upload(filePath string) error {
+	util.LogIfError(ioutil.WriteFile(filePath+uploadPendingSuffix, nil, 0644))
+
+	return s.deliver(&domain.OutboxMessage{
+		Kind:     domain.OutboxKindUpload,
+		FilePath: filePath,
+	})
+}
+
+// ResumeUploads upload pending log files in dir which are not in outbox, ex: agent db removed
+func (s *OutboxService) ResumeUploads(logDir string) {
+	markers, err := filepath.Glob(filepath.Join(logDir, "*"+uploadPendingSuffix))
+	if util.LogIfError(err) {
+		return
+	}
+
+	for _, marker := range markers {
+		filePath := strings.TrimSuffix(marker, uploadPendingSuffix)
+
+		if !util.IsFileExists(filePath) {
+			_ = os.Remove(marker)
+			continue
+		}
+
+		if s.db != nil {
+			query := dao.Query{Where: "kind=? and file_path=?", Args: []interface{}{domain.OutboxKindUpload, filePath}}
+			if count, err := s.db.Count(domain.OutboxMessage{}, query); err == nil && count > 0 {
+				continue
+			}
+		}
+
+		util.LogInfo("[Outbox]: resume uploading log file %s", filePath)
+		util.LogIfError(s.Upload(filePath))
+	}
+}
+
+// Stats backlog of outbox
+func (s *OutboxService) Stats() domain.OutboxStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stats
+}
+
+// Flush send buffered messages of each lane in order, the lane stops on the first failure
+func (s *OutboxService) Flush() (out error) {
+	for _, lane := range s.lanes() {
+		if err := s.flushLane(lane); err != nil && out == nil {
+			out = err
+		}
+	}
+	return
+}
+
+func (s *OutboxService) start() {
+	for _, lane := range s.lanes() {
+		go s.work(lane)
+	}
+}
+
+func (s *OutboxService) work(lane *outboxLane) {
+	config := config.GetInstance()
+	defer util.LogDebug("[Exit]: Outbox %s worker", lane.name)
+
+	for {
+		select {
+		case <-config.AppCtx.Done():
+			return
+		case <-lane.notify:
+		case <-time.After(outboxFlushInterval):
+		}
+
+		if err := s.flushLane(lane); err != nil {
+			util.LogDebug("[Outbox]: %d %s pending: %v", s.pendingOf(lane), lane.name, err)
+		}
+	}
+}
+
+func (s *OutboxService) lanes() []*outboxLane {
+	return []*outboxLane{s.messages, s.uploads}
+}
+
+func (s *OutboxService) laneOf(msg *domain.OutboxMessage) *outboxLane {
+	if msg.Kind == domain.OutboxKindUpload {
+		return s.uploads
+	}
+	return s.messages
+}
+
+func (s *OutboxService) pendingOf(lane *outboxLane) int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return lane.pending
+}
+
+// send directly if nothing buffered in the lane, otherwise append to outbox to keep the order
+func (s *OutboxService) deliver(msg *domain.OutboxMessage) error {
+	lane := s.laneOf(msg)
+
+	lane.sendMux.Lock()
+	defer lane.sendMux.Unlock()
+
+	if s.pendingOf(lane) == 0 {
+		err := s.send(msg)
+		if err == nil {
+			return nil
+		}
+
+		util.LogWarn("[Outbox]: unable to send %s message, buffered: %v", msg.Kind, err)
+	}
+
+	if s.db == nil {
+		return ErrorOutboxNotAvailable
+	}
+
+	if err := s.save(msg, lane); err != nil {
+		return err
+	}
+
+	select {
+	case lane.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// save message to db, the oldest log messages will be evicted if outbox is full
+func (s *OutboxService) save(msg *domain.OutboxMessage, lane *outboxLane) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.maxSize > 0 && s.stats.Size+msg.Size > s.maxSize {
+		s.evictLogs(msg.Size)
+
+		if s.stats.Size+msg.Size > s.maxSize {
+			util.LogWarn("[Outbox]: outbox is full, %s message dropped", msg.Kind)
+			return ErrorOutboxFull
+		}
+	}
+
+	// seq is increased for the order of messages across agent restarts
+	seq := time.Now().UnixNano()
+	if seq <= s.seq {
+		seq = s.seq + 1
+	}
+
+	msg.Seq = seq
+	msg.CreatedAt = time.Now()
+
+	if err := s.db.Save(msg); err != nil {
+		return err
+	}
+
+	s.seq = seq
+	s.stats.Count++
+	s.stats.Size += msg.Size
+	lane.pending++
+	return nil
+}
+
+// evict oldest logs which are not being sent, should be called with lock
+func (s *OutboxService) evictLogs(required int64) {
+	var logs []*domain.OutboxMessage
+	query := dao.Query{
+		Where:   "kind=?",
+		Args:    []interface{}{domain.OutboxKindLog},
+		OrderBy: "seq",
+		Size:    outboxBatchSize,
+	}
+
+	for s.stats.Size+required > s.maxSize {
+		if util.LogIfError(s.db.FindWhere(&logs, query)) || len(logs) == 0 {
+			return
+		}
+
+		evicted := 0
+		for _, log := range logs {
+			if s.inflight[log.Seq] {
+				continue
+			}
+
+			if util.LogIfError(s.db.Delete(log)) {
+				return
+			}
+
+			evicted++
+			s.stats.Count--
+			s.stats.Size -= log.Size
+			s.messages.pending--
+
+			if s.stats.Size+required <= s.maxSize {
+				break
+			}
+		}
+
+		if evicted == 0 {
+			return
+		}
+
+		util.LogWarn("[Outbox]: outbox is full, the oldest log messages dropped")
+	}
+}
+
+func (s *OutboxService) flushLane(lane *outboxLane) error {
+	for {
+		done, err := s.flushBatch(lane)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// flush one batch of lane, new message of the lane will be appended after the batch,
+// the outbox lock is held only to read and delete rows
+func (s *OutboxService) flushBatch(lane *outboxLane) (bool, error) {
+	lane.sendMux.Lock()
+	defer lane.sendMux.Unlock()
+
+	batch, err := s.nextBatch(lane)
+	if err != nil {
+		return false, err
+	}
+
+	if len(batch) == 0 {
+		return true, nil
+	}
+
+	defer s.release(batch)
+
+	for _, msg := range batch {
+		if err = s.send(msg); err != nil {
+			return false, err
+		}
+
+		if err = s.remove(msg, lane); err != nil {
+			return false, err
+		}
+	}
+
+	pending := s.pendingOf(lane)
+	util.LogInfo("[Outbox]: %d %s flushed, %d pending", len(batch), lane.name, pending)
+	return pending == 0, nil
+}
+
+// read buffered messages of lane and mark them as inflight
+func (s *OutboxService) nextBatch(lane *outboxLane) ([]*domain.OutboxMessage, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.db == nil || lane.pending == 0 {
+		return nil, nil
+	}
+
+	var batch []*domain.OutboxMessage
+	if err := s.db.FindWhere(&batch, lane.query(outboxBatchSize)); err != nil {
+		return nil, err
+	}
+
+	// the pending count is out of sync with db
+	if len(batch) == 0 {
+		lane.pending = 0
+		if s.messages.pending == 0 && s.uploads.pending == 0 {
+			s.stats = domain.OutboxStats{}
+		}
+	}
+
+	for _, msg := range batch {
+		s.inflight[msg.Seq] = true
+	}
+
+	return batch, nil
+}
+
+func (s *OutboxService) remove(msg *domain.OutboxMessage, lane *outboxLane) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := s.db.Delete(msg); err != nil {
+		return err
+	}
+
+	delete(s.inflight, msg.Seq)
+	s.stats.Count--
+	s.stats.Size -= msg.Size
+	lane.pending--
+	return nil
+}
+
+func (s *OutboxService) release(batch []*domain.OutboxMessage) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, msg := range batch {
+		delete(s.inflight, msg.Seq)
+	}
+}
+
+func (lane *outboxLane) query(size int) dao.Query {
+	return dao.Query{
+		Where:   lane.where,
+		Args:    []interface{}{domain.OutboxKindUpload},
+		OrderBy: "seq",
+		Size:    size,
+	}
+}
+
+func sendOutboxMessage(msg *domain.OutboxMessage) error {
+	config := config.GetInstance()
+
+	if msg.Kind == domain.OutboxKindUpload {
+		return uploadLogFile(config, msg.FilePath)
+	}
+
+	queue := config.GetQueue()
+	if queue == nil || queue.IsClosed() {
+		return ErrorQueueNotConnected
+	}
+
+	publishing := amqp.Publishing{
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.Encoding,
+		Body:            msg.Body,
+	}
+
+	if msg.Kind == domain.OutboxKindLog {
+		return queue.PublishLog(msg.Exchange, msg.RoutingKey, publishing)
+	}
+
+	// result should be kept on broker restart
+	publishing.DeliveryMode = amqp.Persistent
+
+	var err error
+	for attempt := 0; attempt <= publishMaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(util.Backoff(attempt-1, publishMinBackoff, publishMaxBackoff))
+		}
+
+		err = queue.Publish(msg.Exchange, msg.RoutingKey, publishing)
+		if !isRejectedByBroker(err) {
+			return err
+		}
+
+		util.LogWarn("[Outbox]: %s message not accepted by broker: %v", msg.Kind, err)
+	}
+
+	return err
+}
+
+func isRejectedByBroker(err error) bool {
+	return err == config.ErrMessageNotConfirmed || err == config.ErrMessageReturned
+}
+
+// the file which is removed or rejected by server will not be retried
+func uploadLogFile(config *config.Manager, filePath string) error {
+	if config.Client == nil {
+		return nil
+	}
+
+	if !util.IsFileExists(filePath) {
+		util.LogWarn("[Outbox]: log file %s not found, skip uploading", filePath)
+		_ = os.Remove(filePath + uploadPendingSuffix)
+		return nil
+	}
+
+	err := config.Client.UploadLog(filePath)
+	if api.IsResponseError(err) {
+		util.LogWarn("[Outbox]: log file %s rejected by server: %v", filePath, err)
+		_ = os.Remove(filePath + uploadPendingSuffix)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_ = os.Remove(filePath + uploadPendingSuffix)
+	util.LogDebug("[Uploaded]: %s", filePath)
+	return nil
+}
+
+// log file or marker of log file which is not uploaded
+func isUploadPending(filePath string) bool {
+	return strings.HasSuffix(filePath, uploadPendingSuffix) || util.IsFileExists(filePath+uploadPendingSuffix)
+}
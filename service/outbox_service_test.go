@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github/flowci/flow-agent-x/dao"
+	"github/flowci/flow-agent-x/domain"
+	"github/flowci/flow-agent-x/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldBufferMessagesAndFlushInOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	db, dir := createOutboxTestDB(assert)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	var sent []string
+	offline := true
+
+	outbox := newOutboxService(db, 0)
+	outbox.send = func(msg *domain.OutboxMessage) error {
+		if offline {
+			return ErrorQueueNotConnected
+		}
+		sent = append(sent, string(msg.Body)+msg.FilePath)
+		return nil
+	}
+
+	// when: server unavailable
+	assert.NoError(outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindLog, Exchange: "logs", Body: []byte("log-1")}))
+	assert.NoError(outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindResult, RoutingKey: "callback", Body: []byte("result-1")}))
+	logFile := filepath.Join(dir, "1.log")
+	assert.NoError(outbox.Upload(logFile))
+
+	// then: all messages buffered
+	assert.Equal(domain.OutboxStats{Count: 3, Size: 13}, outbox.Stats())
+	assert.Error(outbox.Flush())
+
+	// then: backlog loaded from db after restart
+	outbox = newOutboxService(db, 0)
+	outbox.send = func(msg *domain.OutboxMessage) error {
+		sent = append(sent, string(msg.Body)+msg.FilePath)
+		return nil
+	}
+	assert.Equal(int64(3), outbox.Stats().Count)
+
+	// when: connectivity returns
+	offline = false
+	assert.NoError(outbox.Flush())
+
+	// then: flushed in order
+	assert.Equal([]string{"log-1", "result-1", logFile}, sent)
+	assert.Equal(domain.OutboxStats{}, outbox.Stats())
+}
+
+func TestShouldNotBlockResultsByUploading(t *testing.T) {
+	assert := assert.New(t)
+
+	db, dir := createOutboxTestDB(assert)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	uploading := make(chan struct{})
+	done := make(chan struct{})
+
+	outbox := newOutboxService(db, 0)
+	outbox.send = func(msg *domain.OutboxMessage) error {
+		if msg.Kind == domain.OutboxKindUpload {
+			close(uploading)
+			<-done
+		}
+		return nil
+	}
+
+	go func() {
+		_ = outbox.Upload(filepath.Join(dir, "1.log"))
+	}()
+	<-uploading
+
+	// when: result published while log file is uploading
+	published := make(chan error)
+	go func() {
+		published <- outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindResult, Body: []byte("result")})
+	}()
+
+	// then: result is sent and stats is available
+	select {
+	case err := <-published:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("result is blocked by uploading")
+	}
+
+	assert.Equal(domain.OutboxStats{}, outbox.Stats())
+	close(done)
+}
+
+func TestShouldEvictOldestLogsIfOutboxFull(t *testing.T) {
+	assert := assert.New(t)
+
+	db, dir := createOutboxTestDB(assert)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	outbox := newOutboxService(db, 10)
+	outbox.send = func(msg *domain.OutboxMessage) error {
+		return errors.New("offline")
+	}
+
+	assert.NoError(outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindLog, Exchange: "logs", Body: []byte("12345")}))
+	assert.NoError(outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindResult, RoutingKey: "callback", Body: []byte("12345")}))
+
+	// when: full
+	assert.NoError(outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindResult, RoutingKey: "callback", Body: []byte("abcde")}))
+
+	// then: the log evicted
+	var messages []*domain.OutboxMessage
+	assert.NoError(db.FindWhere(&messages, dao.Query{OrderBy: "seq"}))
+	assert.Equal(2, len(messages))
+	assert.Equal(domain.OutboxKindResult, messages[0].Kind)
+	assert.Equal("abcde", string(messages[1].Body))
+
+	// then: dropped if no log to evict
+	assert.Equal(ErrorOutboxFull, outbox.Publish(&domain.OutboxMessage{Kind: domain.OutboxKindResult, RoutingKey: "callback", Body: []byte("x")}))
+}
+
+func TestShouldResumePendingUploadsNotInOutbox(t *testing.T) {
+	assert := assert.New(t)
+
+	db, dir := createOutboxTestDB(assert)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	var uploaded []string
+	offline := true
+
+	outbox := newOutboxService(db, 0)
+	outbox.send = func(msg *domain.OutboxMessage) error {
+		if offline {
+			return ErrorQueueNotConnected
+		}
+		uploaded = append(uploaded, filepath.Base(msg.FilePath))
+		return nil
+	}
+
+	// init: 1.log in outbox, 2.log pending but not in outbox, 3.log removed
+	for _, name := range []string{"1.log", "2.log", "3.log"} {
+		_ = ioutil.WriteFile(filepath.Join(dir, name), []byte("log"), 0644)
+	}
+
+	assert.NoError(outbox.Upload(filepath.Join(dir, "1.log")))
+	_ = ioutil.WriteFile(filepath.Join(dir, "2.log"+uploadPendingSuffix), nil, 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "3.log"+uploadPendingSuffix), nil, 0644)
+	_ = os.Remove(filepath.Join(dir, "3.log"))
+
+	assert.True(isUploadPending(filepath.Join(dir, "1.log")))
+	assert.True(isUploadPending(filepath.Join(dir, "2.log")))
+
+	// when:
+	offline = false
+	outbox.ResumeUploads(dir)
+	assert.NoError(outbox.Flush())
+
+	// then: uploaded once for each pending log
+	assert.Equal([]string{"1.log", "2.log"}, uploaded)
+	assert.False(util.IsFileExists(filepath.Join(dir, "3.log"+uploadPendingSuffix)))
+}
+
+func createOutboxTestDB(assert *assert.Assertions) (*dao.Client, string) {
+	dir, err := ioutil.TempDir("", "agent_outbox_test_")
+	assert.NoError(err)
+
+	db, err := dao.NewInstance(filepath.Join(dir, "agent.db"))
+	assert.NoError(err)
+	assert.NoError(db.Migrate([]interface{}{domain.OutboxMessage{}}, nil))
+	return db, dir
+}
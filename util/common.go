@@ -47,55 +47,203 @@ func IsWindows() bool {
 	return runtime.GOOS == OSWin
 }
 
+// VariableLookup get value of variable and whether it's defined
+type VariableLookup func(name string) (string, bool)
+
 // ParseString parse string which include system env variable
 func ParseString(src string) string {
-	return parseVariablesFrom(src, os.Getenv)
+	out, _ := ExpandString(src, os.LookupEnv)
+	return out
 }
 
 func ParseStringWithSource(src string, source map[string]string) string {
-	return parseVariablesFrom(src, func(env string) string {
-		return source[env]
+	out, _ := ExpandString(src, func(name string) (string, bool) {
+		val, ok := source[name]
+		return val, ok
 	})
+	return out
+}
+
+// ExpandString expand variables with shell-like semantics:
+//
+//	$VAR, ${VAR}   value of VAR, keep placeholder if VAR is undefined
+//	${VAR:-word}   word if VAR is undefined or empty
+//	${VAR-word}    word if VAR is undefined
+//	${VAR:+word}   word if VAR is defined and not empty, otherwise empty
+//	${VAR:?msg}    error with msg if VAR is undefined or empty
+//	$$             literal '$'
+//
+// the word could contain nested variables
+func ExpandString(src string, lookup VariableLookup) (string, error) {
+	return expandString(src, lookup, false)
 }
 
-// replace ${VAR} with actual variable value
-func parseVariablesFrom(src string, getVariable func(string) string) string {
-	if IsEmptyString(src) {
-		return src
+// ExpandBraced expand ${...} only with same semantics of ExpandString, except the undefined ${VAR} is empty
+// as os.ExpandEnv, the $VAR and $$ are kept
+func ExpandBraced(src string, lookup VariableLookup) (string, error) {
+	return expandString(src, lookup, true)
+}
+
+func expandString(src string, lookup VariableLookup, bracedOnly bool) (string, error) {
+	if !strings.Contains(src, "$") {
+		return src, nil
 	}
 
+	var out strings.Builder
+	out.Grow(len(src))
+
 	for i := 0; i < len(src); i++ {
-		if src[i] != '$' {
+		c := src[i]
+
+		if c != '$' || i == len(src)-1 {
+			out.WriteByte(c)
 			continue
 		}
 
-		// left bracket index
-		lIndex := i + 1
-		if src[lIndex] != '{' {
+		next := src[i+1]
+
+		if bracedOnly && next != '{' {
+			out.WriteByte(c)
+			continue
+		}
+
+		// escaped $$
+		if next == '$' {
+			out.WriteByte('$')
+			i++
 			continue
 		}
 
-		// find right bracket index
-		for rIndex := lIndex + 1; rIndex < len(src); rIndex++ {
-			if src[rIndex] != '}' {
-				continue
+		// $VAR
+		if next != '{' {
+			end := i + 1
+			for end < len(src) && isVariableChar(src[end], end == i+1) {
+				end++
 			}
 
-			env := src[lIndex+1 : rIndex]
-			val := getVariable(env)
+			name := src[i+1 : end]
+			val, ok := lookup(name)
 
-			// do not replace if no value found
-			if IsEmptyString(val) {
-				break
+			if len(name) == 0 || !ok {
+				out.WriteString(src[i:end])
+			} else {
+				out.WriteString(val)
 			}
 
-			src = strings.Replace(src, fmt.Sprintf("${%s}", env), val, -1)
-			i = rIndex
+			i = end - 1
+			continue
+		}
+
+		// ${...} find the matched right bracket for nested expression
+		end := findRightBracket(src, i+2)
+		if end == -1 {
+			out.WriteString(src[i:])
 			break
 		}
+
+		val, err := expandBracket(src[i:end+1], src[i+2:end], lookup, bracedOnly)
+		if err != nil {
+			return src, err
+		}
+
+		out.WriteString(val)
+		i = end
+	}
+
+	return out.String(), nil
+}
+
+// expand content in ${...}
+func expandBracket(raw, expr string, lookup VariableLookup, bracedOnly bool) (string, error) {
+	nameEnd := 0
+	for nameEnd < len(expr) && isVariableChar(expr[nameEnd], nameEnd == 0) {
+		nameEnd++
+	}
+
+	name := expr[:nameEnd]
+	op := expr[nameEnd:]
+
+	if len(name) == 0 {
+		return raw, nil
+	}
+
+	val, ok := lookup(name)
+
+	if len(op) == 0 {
+		if !ok && !bracedOnly {
+			return raw, nil
+		}
+		return val, nil
 	}
 
-	return src
+	isEmpty := !ok || len(val) == 0
+
+	switch {
+	case strings.HasPrefix(op, ":-"):
+		if isEmpty {
+			return expandString(op[2:], lookup, bracedOnly)
+		}
+		return val, nil
+
+	case strings.HasPrefix(op, "-"):
+		if !ok {
+			return expandString(op[1:], lookup, bracedOnly)
+		}
+		return val, nil
+
+	case strings.HasPrefix(op, ":+"):
+		if isEmpty {
+			return EmptyStr, nil
+		}
+		return expandString(op[2:], lookup, bracedOnly)
+
+	case strings.HasPrefix(op, ":?"):
+		if !isEmpty {
+			return val, nil
+		}
+
+		msg, err := expandString(op[2:], lookup, bracedOnly)
+		if err != nil {
+			return raw, err
+		}
+
+		if IsEmptyString(msg) {
+			msg = "parameter null or not set"
+		}
+		return raw, fmt.Errorf("%s: %s", name, msg)
+	}
+
+	// unsupported operator, keep it as it is
+	return raw, nil
+}
+
+// find index of '}' which matched the '${' before start
+func findRightBracket(src string, start int) int {
+	depth := 1
+
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			if src[i-1] == '$' {
+				depth++
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func isVariableChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+
+	return !first && c >= '0' && c <= '9'
 }
 
 func GetEnv(env, def string) string {
@@ -109,3 +257,8 @@ func GetEnv(env, def string) string {
 func ByteToMB(bytes uint64) uint64 {
 	return (bytes / 1024) / 1024
 }
+
+// ShellQuote quote string by single quotes for shell, the single quote inside is escaped
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -36,3 +36,65 @@ func TestShouldParseStringWithEnvVariable(t *testing.T) {
 	assert.Equal(usr.HomeDir+usr.HomeDir, ParseString("${HOME}${HOME}"))
 
 }
+
+func TestShouldExpandStringWithShellSemantics(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := map[string]string{
+		"NAME":  "flow",
+		"EMPTY": "",
+		"TAG":   "1.0",
+	}
+
+	assert.Equal("flow.ci", ParseStringWithSource("$NAME.ci", vars))
+	assert.Equal("flow-1.0", ParseStringWithSource("${NAME}-${TAG}", vars))
+	assert.Equal("${UNDEFINED}/$UNDEFINED", ParseStringWithSource("${UNDEFINED}/$UNDEFINED", vars))
+
+	assert.Equal("latest", ParseStringWithSource("${EMPTY:-latest}", vars))
+	assert.Equal("", ParseStringWithSource("${EMPTY-latest}", vars))
+	assert.Equal("latest", ParseStringWithSource("${UNDEFINED-latest}", vars))
+	assert.Equal("1.0", ParseStringWithSource("${TAG:-latest}", vars))
+
+	assert.Equal("alt", ParseStringWithSource("${NAME:+alt}", vars))
+	assert.Equal("", ParseStringWithSource("${EMPTY:+alt}", vars))
+
+	// nested and escaped
+	assert.Equal("ubuntu:1.0", ParseStringWithSource("ubuntu:${UNDEFINED:-${TAG}}", vars))
+	assert.Equal("${NAME} $", ParseStringWithSource("$${NAME} $", vars))
+	assert.Equal("price $$5", ParseStringWithSource("price $$$$5", vars))
+}
+
+func TestShouldExpandBracedOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	lookup := func(name string) (string, bool) {
+		val, ok := map[string]string{"NAME": "flow"}[name]
+		return val, ok
+	}
+
+	out, err := ExpandBraced("${NAME} $NAME pa$$word ${UNDEFINED:-$NAME} [${UNDEFINED}]", lookup)
+	assert.NoError(err)
+	assert.Equal("flow $NAME pa$$word $NAME []", out)
+}
+
+func TestShouldReturnErrorIfRequiredVariableMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	lookup := func(name string) (string, bool) {
+		return "", false
+	}
+
+	_, err := ExpandString("${IMAGE:?image is required}", lookup)
+	assert.Error(err)
+	assert.Equal("IMAGE: image is required", err.Error())
+
+	_, err = ExpandString("${IMAGE:?}", lookup)
+	assert.Equal("IMAGE: parameter null or not set", err.Error())
+}
+
+func TestShouldQuoteStringForShell(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("'run.sh'", ShellQuote("run.sh"))
+	assert.Equal(`'it'\''s $HOME.sh'`, ShellQuote("it's $HOME.sh"))
+}
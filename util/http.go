@@ -3,6 +3,7 @@ package util
 const (
 	HttpMimeJson  = "application/json"
 	HttpProtobuf = "application/x-protobuf"
+	HttpMimeGzip = "application/gzip"
 
 	HttpHeaderContentType = "Content-Type"
 	HttpHeaderAgentToken = "AGENT-TOKEN"
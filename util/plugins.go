@@ -1,11 +1,25 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const (
+	// PluginVersionSeparator separator of plugin name and version, ex: name@v1.0
+	PluginVersionSeparator = "@"
+
+	pluginGitDir = ".git"
 )
 
 type Plugins struct {
@@ -23,10 +37,90 @@ func NewPlugins(dir, server string) *Plugins {
 	}
 }
 
-func (p *Plugins) Load(name string) error {
+// ParsePlugin parse plugin string 'name@version' to name and version, version is empty if not pinned
+func ParsePlugin(plugin string) (name, version string) {
+	index := strings.LastIndex(plugin, PluginVersionSeparator)
+	if index == -1 {
+		return plugin, EmptyStr
+	}
+
+	return plugin[:index], plugin[index+1:]
+}
+
+// Dir local dir of plugin, the pinned version is stored as 'name@version' side by side
+func (p *Plugins) Dir(plugin string) string {
+	return filepath.Join(p.dir, plugin)
+}
+
+// Load clone or pull plugin by 'name' or 'name@version',
+// the pinned version will not touch the network if it's already present
+func (p *Plugins) Load(plugin string) error {
+	name, version := ParsePlugin(plugin)
 	url := p.server + "/git/plugins/" + name
-	dir := filepath.Join(p.dir, name)
 
+	if IsEmptyString(version) {
+		return p.loadLatest(p.Dir(plugin), url)
+	}
+
+	if err := p.loadVersion(p.Dir(plugin), url, version); err != nil {
+		return err
+	}
+
+	// the modified time of pinned version is used to find unused versions
+	now := time.Now()
+	return os.Chtimes(p.Dir(plugin), now, now)
+}
+
+// Checksum sha256 of plugin files which exclude .git dir
+func (p *Plugins) Checksum(plugin string) (string, error) {
+	dir := p.Dir(plugin)
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == pluginGitDir {
+			return filepath.SkipDir
+		}
+
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return EmptyStr, err
+	}
+
+	sort.Strings(files)
+	hash := sha256.New()
+
+	for _, path := range files {
+		rel, _ := filepath.Rel(dir, path)
+		_, _ = io.WriteString(hash, filepath.ToSlash(rel))
+		_, _ = hash.Write([]byte{0})
+
+		f, err := os.Open(path)
+		if err != nil {
+			return EmptyStr, err
+		}
+
+		_, err = io.Copy(hash, f)
+		_ = f.Close()
+
+		if err != nil {
+			return EmptyStr, err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (p *Plugins) loadLatest(dir, url string) error {
 	LogInfo("agent: clone plugin '%s' to '%s'", url, dir)
 
 	err := p.clone(dir, url)
@@ -38,6 +132,49 @@ func (p *Plugins) Load(name string) error {
 	return err
 }
 
+func (p *Plugins) loadVersion(dir, url, version string) (out error) {
+	defer func() {
+		if err := recover(); err != nil {
+			out = err.(error)
+		}
+	}()
+
+	if IsFileExists(dir) {
+		LogInfo("agent: plugin '%s' version '%s' is present", url, version)
+		return nil
+	}
+
+	// checkout to tmp dir and move to dest, to avoid the broken plugin dir
+	tmp := filepath.Join(p.dir, "."+filepath.Base(dir)+".tmp")
+	_ = os.RemoveAll(tmp)
+	defer os.RemoveAll(tmp)
+
+	LogInfo("agent: clone plugin '%s' version '%s' to '%s'", url, version, dir)
+
+	repo, err := git.PlainClone(tmp, false, &git.CloneOptions{
+		URL:      url,
+		Progress: os.Stdout,
+		Tags:     git.AllTags,
+	})
+	PanicIfErr(err)
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(version))
+	if err != nil {
+		panic(fmt.Errorf("agent: version '%s' not found for plugin '%s'", version, url))
+	}
+
+	workTree, err := repo.Worktree()
+	PanicIfErr(err)
+
+	err = workTree.Checkout(&git.CheckoutOptions{
+		Hash:  *hash,
+		Force: true,
+	})
+	PanicIfErr(err)
+
+	return os.Rename(tmp, dir)
+}
+
 func (p *Plugins) clone(dir, url string) error {
 	options := &git.CloneOptions{
 		URL:      url,
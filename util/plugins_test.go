@@ -0,0 +1,84 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestShouldParsePluginNameAndVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	name, version := ParsePlugin("maven-test")
+	assert.Equal("maven-test", name)
+	assert.Equal("", version)
+
+	name, version = ParsePlugin("maven-test@v1.0")
+	assert.Equal("maven-test", name)
+	assert.Equal("v1.0", version)
+}
+
+func TestShouldLoadPinnedPluginVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	// init: plugin repo on server dir with tag v1.0 and a new commit after the tag
+	server, _ := ioutil.TempDir("", "agent_plugin_server_")
+	defer os.RemoveAll(server)
+
+	repoDir := filepath.Join(server, "git", "plugins", "demo")
+	repo, err := git.PlainInit(repoDir, false)
+	assert.NoError(err)
+
+	v1 := commitTestFile(assert, repo, repoDir, "v1")
+	_, err = repo.CreateTag("v1.0", v1, nil)
+	assert.NoError(err)
+	commitTestFile(assert, repo, repoDir, "v2")
+
+	pluginDir, _ := ioutil.TempDir("", "agent_plugins_")
+	defer os.RemoveAll(pluginDir)
+
+	// when:
+	plugins := NewPlugins(pluginDir, server)
+	assert.NoError(plugins.Load("demo@v1.0"))
+	assert.NoError(plugins.Load("demo"))
+
+	// then: versions are side by side
+	content, _ := ioutil.ReadFile(filepath.Join(plugins.Dir("demo@v1.0"), "run.sh"))
+	assert.Equal("v1", string(content))
+
+	content, _ = ioutil.ReadFile(filepath.Join(plugins.Dir("demo"), "run.sh"))
+	assert.Equal("v2", string(content))
+
+	// then: checksum should be stable and exclude .git
+	checksum, err := plugins.Checksum("demo@v1.0")
+	assert.NoError(err)
+	assert.Len(checksum, 64)
+
+	os.RemoveAll(repoDir)
+	assert.NoError(plugins.Load("demo@v1.0"))
+
+	again, _ := plugins.Checksum("demo@v1.0")
+	assert.Equal(checksum, again)
+}
+
+func commitTestFile(assert *assert.Assertions, repo *git.Repository, dir, content string) plumbing.Hash {
+	err := ioutil.WriteFile(filepath.Join(dir, "run.sh"), []byte(content), 0644)
+	assert.NoError(err)
+
+	tree, _ := repo.Worktree()
+	_, err = tree.Add("run.sh")
+	assert.NoError(err)
+
+	hash, err := tree.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "flowci", Email: "flowci@flow.ci", When: time.Now()},
+	})
+	assert.NoError(err)
+	return hash
+}
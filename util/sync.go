@@ -1,6 +1,7 @@
 package util
 
 import (
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -19,4 +20,18 @@ func Wait(group *sync.WaitGroup, timeout time.Duration) bool {
 	case <-time.After(timeout):
 		return false
 	}
-}
\ No newline at end of file
+}
+
+// Backoff exponential backoff duration with full jitter for the given attempt, start from 0
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt > 30 {
+		attempt = 30
+	}
+
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
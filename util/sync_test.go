@@ -42,3 +42,15 @@ func TestShouldTimeoutForSyncGroup(t *testing.T) {
 	r := Wait(&g, 1 * time.Second)
 	assert.False(r)
 }
+
+func TestShouldBackoffWithinMaxDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	for i := 0; i < 100; i++ {
+		d := Backoff(i, 100*time.Millisecond, 10*time.Second)
+		assert.True(d >= 0)
+		assert.True(d <= 10*time.Second)
+	}
+
+	assert.True(Backoff(0, 100*time.Millisecond, 10*time.Second) <= 100*time.Millisecond)
+}
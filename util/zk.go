@@ -45,6 +45,17 @@ func (client *ZkClient) Data(path string) (string, error) {
 	return string(bytes), err
 }
 
+// Set update data of node, the version is ignored
+func (client *ZkClient) Set(path string, data string) error {
+	_, err := client.conn.Set(path, []byte(data), -1)
+	return err
+}
+
+// IsConnected check the session is established
+func (client *ZkClient) IsConnected() bool {
+	return client.conn != nil && client.conn.State() == zk.StateHasSession
+}
+
 func (client *ZkClient) Delete(path string) error {
 	exist, _ := client.Exist(path)
 